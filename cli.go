@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/yujen77300/Chirpy-Server/internal/auth"
+	"github.com/yujen77300/Chirpy-Server/internal/database"
+)
+
+const usage = `usage: chirpy [command]
+
+Without a command the API server is started.
+
+Commands:
+  set-role <email> <role>   change a user's role (user, moderator or admin)`
+
+// runCommand runs one of the management commands, e.g. to make the first
+// admin with "chirpy set-role alice@example.com admin".
+func runCommand(db *database.Queries, args []string) error {
+	switch args[0] {
+	case "set-role":
+		if len(args) != 3 {
+			return errors.New(usage)
+		}
+		return setRole(db, args[1], args[2])
+	default:
+		return errors.New(usage)
+	}
+}
+
+func setRole(db *database.Queries, email, roleName string) error {
+	role, err := auth.ParseRole(roleName)
+	if err != nil {
+		return err
+	}
+
+	user, err := db.SetUserRoleByEmail(context.Background(), database.SetUserRoleByEmailParams{
+		Email: email,
+		Role:  string(role),
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("no user with email %s", email)
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't set role: %w", err)
+	}
+
+	fmt.Printf("%s is now %s\n", user.Email, user.Role)
+	return nil
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/yujen77300/Chirpy-Server/internal/auth"
+	"github.com/yujen77300/Chirpy-Server/internal/utils"
+)
+
+// Delete deactivates the logged in user's account after checking their
+// password again. Their chirps are hidden and every session and access
+// token stops working at once; personal access tokens and app access are
+// suspended. The account is purged once the grace period has passed, unless
+// the user logs back in first.
+func (h *UserHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Password string `json:"password"`
+	}
+	type response struct {
+		PurgeAfter time.Time `json:"purge_after"`
+	}
+
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	user, err := h.db.GetUserByID(r.Context(), principal.UserID)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Couldn't find user")
+		return
+	}
+	if auth.CheckPasswordHash(params.Password, user.HashedPassword) != nil {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Incorrect password")
+		return
+	}
+
+	user, err = h.db.DeactivateUser(r.Context(), user.ID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.RespondWithError(w, http.StatusConflict, "Account is already scheduled for deletion")
+			return
+		}
+		log.Printf("Error deactivating user: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	err = h.db.RevokeUserRefreshTokens(r.Context(), user.ID)
+	if err != nil {
+		log.Printf("Error revoking refresh tokens: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+	err = h.revocations.RevokeUserTokens(r.Context(), user.ID)
+	if err != nil {
+		log.Printf("Error revoking access tokens: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	purgeAfter := user.DeactivatedAt.Time.Add(h.deletionGracePeriod)
+	recordSecurityEvent(r, h.db, user.ID, securityEventAccountDeactivated, "purge after "+purgeAfter.Format(time.RFC3339))
+
+	utils.RespondWithJSON(w, http.StatusOK, response{
+		PurgeAfter: purgeAfter,
+	})
+}
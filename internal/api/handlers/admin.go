@@ -1,24 +1,42 @@
 package handlers
 
 import (
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"sync/atomic"
 
+	"github.com/google/uuid"
+	"github.com/yujen77300/Chirpy-Server/internal/auth"
 	"github.com/yujen77300/Chirpy-Server/internal/database"
+	"github.com/yujen77300/Chirpy-Server/internal/lockout"
+	"github.com/yujen77300/Chirpy-Server/internal/models"
+	"github.com/yujen77300/Chirpy-Server/internal/revocation"
+	"github.com/yujen77300/Chirpy-Server/internal/utils"
 )
 
+// AdminHandler serves the /admin endpoints. Access is checked by the router
+// with the permission each endpoint needs.
 type AdminHandler struct {
 	db             *database.Queries
+	keys           *auth.KeySet
 	platform       string
 	fileserverHits *atomic.Int32
+	guard          *lockout.Guard
+	revocations    *revocation.List
 }
 
-func NewAdminHandler(db *database.Queries, platform string, fileserverHits *atomic.Int32) *AdminHandler {
+func NewAdminHandler(db *database.Queries, keys *auth.KeySet, platform string, fileserverHits *atomic.Int32, guard *lockout.Guard, revocations *revocation.List) *AdminHandler {
 	return &AdminHandler{
 		db:             db,
+		keys:           keys,
 		platform:       platform,
 		fileserverHits: fileserverHits,
+		guard:          guard,
+		revocations:    revocations,
 	}
 }
 
@@ -40,6 +58,8 @@ func (h *AdminHandler) GetMetrics(w http.ResponseWriter, r *http.Request) {
 		h.fileserverHits.Load())))
 }
 
+// Reset wipes the database. Besides PermissionResetDatabase it needs
+// PLATFORM=dev, so it can never run against a production database.
 func (h *AdminHandler) Reset(w http.ResponseWriter, r *http.Request) {
 	if h.platform != "dev" {
 		w.WriteHeader(http.StatusForbidden)
@@ -62,3 +82,145 @@ func (h *AdminHandler) Reset(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("Content-Type", "text/plain")
 	w.Write([]byte("Hits reset to 0"))
 }
+
+// ClearLockout forgets the failed login attempts of an email address and/or
+// client IP, lifting any lockout on them.
+func (h *AdminHandler) ClearLockout(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+		IP    string `json:"ip"`
+	}
+
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		log.Printf("Error decoding JSON: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	var keys []string
+	if params.Email != "" {
+		keys = append(keys, lockout.AccountKey(params.Email))
+	}
+	if params.IP != "" {
+		keys = append(keys, lockout.IPKey(params.IP))
+	}
+	if len(keys) == 0 {
+		utils.RespondWithError(w, http.StatusBadRequest, "An email or IP is required")
+		return
+	}
+
+	for _, key := range keys {
+		err = h.guard.Reset(r.Context(), key)
+		if err != nil {
+			log.Printf("Error clearing lockout: %s", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SetRole changes a user's role. The user's access tokens are revoked, so
+// the new role applies from their next refresh.
+func (h *AdminHandler) SetRole(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Role string `json:"role"`
+	}
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	params := parameters{}
+	err = json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		log.Printf("Error decoding JSON: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	role, err := auth.ParseRole(params.Role)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Role must be user, moderator or admin")
+		return
+	}
+
+	user, err := h.db.SetUserRole(r.Context(), database.SetUserRoleParams{
+		ID:   userID,
+		Role: string(role),
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.RespondWithError(w, http.StatusNotFound, "User not found")
+			return
+		}
+		log.Printf("Error setting role: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	err = h.revocations.RevokeUserTokens(r.Context(), user.ID)
+	if err != nil {
+		log.Printf("Error revoking access tokens: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+	recordSecurityEvent(r, h.db, user.ID, securityEventRoleChanged, "to "+user.Role+" by admin "+principal.UserID.String())
+
+	utils.RespondWithJSON(w, http.StatusOK, models.User{
+		ID:              user.ID,
+		CreatedAt:       user.CreatedAt,
+		UpdatedAt:       user.UpdatedAt,
+		Email:           user.Email,
+		IsChirpyRed:     user.IsChirpyRed,
+		IsEmailVerified: user.EmailVerifiedAt.Valid,
+		Role:            user.Role,
+	})
+}
+
+// RevokeTokens signs a user out everywhere: their refresh tokens, personal
+// access tokens and app authorizations are revoked and every access token
+// they hold stops working immediately.
+func (h *AdminHandler) RevokeTokens(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	_, err = h.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.RespondWithError(w, http.StatusNotFound, "User not found")
+			return
+		}
+		log.Printf("Error getting user: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	err = revokeAllCredentials(r.Context(), h.db, h.revocations, userID)
+	if err != nil {
+		log.Printf("Error revoking credentials: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+	recordSecurityEvent(r, h.db, userID, securityEventTokensRevoked, "by admin "+principal.UserID.String())
+
+	w.WriteHeader(http.StatusNoContent)
+}
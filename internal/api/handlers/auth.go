@@ -1,37 +1,64 @@
 package handlers
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/yujen77300/Chirpy-Server/internal/auth"
 	"github.com/yujen77300/Chirpy-Server/internal/database"
+	"github.com/yujen77300/Chirpy-Server/internal/lockout"
 	"github.com/yujen77300/Chirpy-Server/internal/models"
 	"github.com/yujen77300/Chirpy-Server/internal/utils"
 )
 
+const (
+	// Refresh tokens should expire after 60 days.
+	refreshTokenTTL = time.Hour * 24 * 60
+	// mfaTokenTTL is how long a user has to complete the second login step.
+	mfaTokenTTL = time.Minute * 5
+)
+
+// dummyPasswordHash is checked against when the email is unknown, so a failed
+// login takes as long whether or not the account exists.
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, _ := auth.HashPassword("chirpy-dummy-password")
+	return hash
+})
+
 type AuthHandler struct {
-	db        *database.Queries
-	jwtSecret string
+	db    *database.Queries
+	keys  *auth.KeySet
+	guard *lockout.Guard
 }
 
-func NewAuthHandler(db *database.Queries, jwtSecret string) *AuthHandler {
+func NewAuthHandler(db *database.Queries, keys *auth.KeySet, guard *lockout.Guard) *AuthHandler {
 	return &AuthHandler{
-		db:        db,
-		jwtSecret: jwtSecret,
+		db:    db,
+		keys:  keys,
+		guard: guard,
 	}
 }
 
+type loginResponse struct {
+	models.User
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
 func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
-		Password string `json:"password"`
-		Email    string `json:"email"`
-	}
-	type response struct {
-		models.User
-		Token        string `json:"token"`
-		RefreshToken string `json:"refresh_token"`
+		Password    string `json:"password"`
+		Email       string `json:"email"`
+		DeviceLabel string `json:"device_label"`
 	}
 
 	decoder := json.NewDecoder(r.Body)
@@ -46,57 +73,296 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	// 	params.ExpiresIn = 3600
 	// }
 
+	attemptKeys := []string{lockout.AccountKey(params.Email), lockout.IPKey(utils.ClientIP(r))}
+	if !h.checkLockout(w, r, attemptKeys) {
+		return
+	}
+
 	user, err := h.db.GetUserByEmail(r.Context(), params.Email)
 	if err != nil {
-		utils.RespondWithError(w, http.StatusUnauthorized, "Incorrect email or password")
+		auth.CheckPasswordHash(params.Password, dummyPasswordHash())
+		recordSecurityEvent(r, h.db, uuid.Nil, securityEventLoginFailed, "unknown email")
+		h.loginFailed(w, r, attemptKeys, "Incorrect email or password")
 		return
 	}
 
 	err = auth.CheckPasswordHash(params.Password, user.HashedPassword)
 	if err != nil {
-		utils.RespondWithError(w, http.StatusUnauthorized, "Incorrect email or password")
+		recordSecurityEvent(r, h.db, user.ID, securityEventLoginFailed, "wrong password")
+		h.loginFailed(w, r, attemptKeys, "Incorrect email or password")
 		return
 	}
+	if auth.PasswordNeedsRehash(user.HashedPassword) {
+		go h.rehashPassword(user, params.Password)
+	}
 
-	accessToken, err := auth.MakeJWT(user.ID, h.jwtSecret, time.Hour)
+	err = h.guard.Reset(r.Context(), lockout.AccountKey(params.Email))
 	if err != nil {
-		utils.RespondWithError(w, http.StatusInternalServerError, "Couldn't create access JWT")
+		log.Printf("Error resetting login attempts: %s", err)
+	}
+
+	h.completeFirstFactor(w, r, user, params.DeviceLabel)
+}
+
+// completeFirstFactor finishes a login whose first factor has been checked.
+// Accounts with two-factor authentication get an MFA token for the second
+// step instead of a session.
+func (h *AuthHandler) completeFirstFactor(w http.ResponseWriter, r *http.Request, user database.User, deviceLabel string) {
+	type mfaResponse struct {
+		MFARequired bool   `json:"mfa_required"`
+		MFAToken    string `json:"mfa_token"`
+	}
+
+	totp, err := h.db.GetTOTPCredential(r.Context(), user.ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Couldn't check two-factor authentication")
+		return
+	}
+	if err == nil && totp.ConfirmedAt.Valid {
+		mfaToken, err := auth.MakeMFAToken(user.ID, h.keys, mfaTokenTTL)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "Couldn't create MFA token")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, mfaResponse{
+			MFARequired: true,
+			MFAToken:    mfaToken,
+		})
 		return
 	}
 
-	refreshToken, err := auth.MakeRefreshToken()
+	h.issueTokens(w, r, user, deviceLabel)
+}
+
+// LoginMFA completes a login for accounts with two-factor authentication,
+// using either a TOTP code or one of the user's recovery codes.
+func (h *AuthHandler) LoginMFA(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+		DeviceLabel  string `json:"device_label"`
+	}
+
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
 	if err != nil {
-		utils.RespondWithError(w, http.StatusInternalServerError, "Couldn't create refresh token")
+		utils.RespondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters")
+		return
+	}
+
+	userID, err := auth.ValidateMFAToken(params.MFAToken, h.keys)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Invalid or expired MFA token")
+		return
+	}
+
+	user, err := h.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Couldn't find user")
 		return
 	}
 
-	_, err = h.db.CreateRefreshToken(r.Context(), database.CreateRefreshTokenParams{
-		UserID: user.ID,
-		Token:  refreshToken,
-		// Refresh tokens should expire after 60 days.
-		ExpiresAt: time.Now().UTC().Add(time.Hour * 24 * 60),
+	// Codes are short, so guessing them counts against the same limits as
+	// guessing passwords.
+	attemptKeys := []string{lockout.AccountKey(user.Email), lockout.IPKey(utils.ClientIP(r))}
+	if !h.checkLockout(w, r, attemptKeys) {
+		return
+	}
+
+	switch {
+	case params.Code != "":
+		totp, err := h.db.GetTOTPCredential(r.Context(), userID)
+		if err != nil || !totp.ConfirmedAt.Valid {
+			utils.RespondWithError(w, http.StatusUnauthorized, "Two-factor authentication is not enabled")
+			return
+		}
+		step, ok := auth.ValidateTOTP(params.Code, totp.Secret, time.Now())
+		if !ok {
+			recordSecurityEvent(r, h.db, userID, securityEventLoginFailed, "wrong TOTP code")
+			h.loginFailed(w, r, attemptKeys, "Invalid code")
+			return
+		}
+		_, err = h.db.UseTOTPStep(r.Context(), database.UseTOTPStepParams{
+			Step:   step,
+			UserID: userID,
+		})
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				utils.RespondWithError(w, http.StatusUnauthorized, "Code has already been used")
+				return
+			}
+			utils.RespondWithError(w, http.StatusInternalServerError, "Couldn't verify code")
+			return
+		}
+	case params.RecoveryCode != "":
+		_, err = h.db.UseRecoveryCode(r.Context(), database.UseRecoveryCodeParams{
+			UserID:   userID,
+			CodeHash: auth.HashToken(strings.ToLower(strings.TrimSpace(params.RecoveryCode))),
+		})
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				recordSecurityEvent(r, h.db, userID, securityEventLoginFailed, "wrong recovery code")
+				h.loginFailed(w, r, attemptKeys, "Invalid recovery code")
+				return
+			}
+			utils.RespondWithError(w, http.StatusInternalServerError, "Couldn't verify recovery code")
+			return
+		}
+	default:
+		utils.RespondWithError(w, http.StatusBadRequest, "A code or recovery code is required")
+		return
+	}
+
+	err = h.guard.Reset(r.Context(), lockout.AccountKey(user.Email))
+	if err != nil {
+		log.Printf("Error resetting login attempts: %s", err)
+	}
+
+	h.issueTokens(w, r, user, params.DeviceLabel)
+}
+
+type lockoutResponse struct {
+	Error      string `json:"error"`
+	RetryAfter int    `json:"retry_after"`
+}
+
+// checkLockout responds with 429 and returns false while any of the keys is
+// locked out. If the store fails the login is let through rather than
+// locking everyone out.
+func (h *AuthHandler) checkLockout(w http.ResponseWriter, r *http.Request, attemptKeys []string) bool {
+	wait, err := h.guard.Check(r.Context(), attemptKeys...)
+	if err != nil {
+		log.Printf("Error checking login attempts: %s", err)
+		return true
+	}
+	if wait > 0 {
+		respondWithRetryAfter(w, http.StatusTooManyRequests, "Too many failed attempts, try again later", wait)
+		return false
+	}
+	return true
+}
+
+// rehashPassword replaces a hash made with an older algorithm or weaker
+// parameters. The update only applies while the old hash is still stored, so
+// a password change in the meantime is never overwritten.
+func (h *AuthHandler) rehashPassword(user database.User, password string) {
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		log.Printf("Error rehashing password: %s", err)
+		return
+	}
+	err = h.db.RehashUserPassword(context.Background(), database.RehashUserPasswordParams{
+		NewHash: hash,
+		ID:      user.ID,
+		OldHash: user.HashedPassword,
 	})
 	if err != nil {
-		utils.RespondWithError(w, http.StatusInternalServerError, "Couldn't save refresh token")
+		log.Printf("Error saving rehashed password: %s", err)
+	}
+}
+
+// loginFailed records a failed attempt and responds with 401, telling the
+// client when it may retry if the failure triggered a lockout.
+func (h *AuthHandler) loginFailed(w http.ResponseWriter, r *http.Request, attemptKeys []string, msg string) {
+	wait, err := h.guard.RecordFailure(r.Context(), attemptKeys...)
+	if err != nil {
+		log.Printf("Error recording failed login: %s", err)
+	}
+	if wait > 0 {
+		respondWithRetryAfter(w, http.StatusUnauthorized, msg, wait)
 		return
 	}
+	utils.RespondWithError(w, http.StatusUnauthorized, msg)
+}
 
-	utils.RespondWithJSON(w, http.StatusOK, response{
+func respondWithRetryAfter(w http.ResponseWriter, code int, msg string, wait time.Duration) {
+	seconds := int(wait.Seconds())
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	utils.RespondWithJSON(w, code, lockoutResponse{
+		Error:      msg,
+		RetryAfter: seconds,
+	})
+}
+
+// issueTokens starts a new session for an authenticated user and responds
+// with the user, an access token and a refresh token.
+func (h *AuthHandler) issueTokens(w http.ResponseWriter, r *http.Request, user database.User, deviceLabel string) {
+	// Logging in cancels a pending account deletion.
+	if user.DeactivatedAt.Valid {
+		err := h.db.ReactivateUser(r.Context(), user.ID)
+		if err != nil {
+			log.Printf("Error reactivating user: %s", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+			return
+		}
+		recordSecurityEvent(r, h.db, user.ID, securityEventAccountReactivated, "")
+	}
+
+	sessionID, accessToken, refreshToken, err := startSession(r, h.db, h.keys, user, deviceLabel)
+	if err != nil {
+		log.Printf("Error starting session: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Couldn't create session")
+		return
+	}
+	recordSecurityEvent(r, h.db, user.ID, securityEventLoginSucceeded, "session "+sessionID.String())
+
+	utils.RespondWithJSON(w, http.StatusOK, loginResponse{
 		User: models.User{
-			ID:          user.ID,
-			Email:       user.Email,
-			CreatedAt:   user.CreatedAt,
-			UpdatedAt:   user.UpdatedAt,
-			IsChirpyRed: user.IsChirpyRed,
+			ID:              user.ID,
+			Email:           user.Email,
+			CreatedAt:       user.CreatedAt,
+			UpdatedAt:       user.UpdatedAt,
+			IsChirpyRed:     user.IsChirpyRed,
+			IsEmailVerified: user.EmailVerifiedAt.Valid,
+			Role:            user.Role,
 		},
 		Token:        accessToken,
 		RefreshToken: refreshToken,
 	})
 }
 
+// startSession creates a new token family for the user on the requesting
+// device and returns its ID with the first access and refresh tokens. opts
+// are added to the access token.
+func startSession(r *http.Request, db *database.Queries, keys *auth.KeySet, user database.User, deviceLabel string, opts ...auth.ClaimOption) (uuid.UUID, string, string, error) {
+	// Every login starts a new token family; rotations stay in it.
+	sessionID := uuid.New()
+
+	opts = append([]auth.ClaimOption{auth.WithSessionID(sessionID), auth.WithRole(auth.Role(user.Role))}, opts...)
+	accessToken, err := auth.MakeJWT(user.ID, keys, time.Hour, opts...)
+	if err != nil {
+		return uuid.Nil, "", "", err
+	}
+
+	refreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		return uuid.Nil, "", "", err
+	}
+
+	_, err = db.CreateRefreshToken(r.Context(), database.CreateRefreshTokenParams{
+		UserID:      user.ID,
+		Token:       refreshToken,
+		ExpiresAt:   time.Now().UTC().Add(refreshTokenTTL),
+		FamilyID:    sessionID,
+		UserAgent:   r.UserAgent(),
+		IpAddress:   utils.ClientIP(r),
+		DeviceLabel: deviceLabel,
+	})
+	if err != nil {
+		return uuid.Nil, "", "", err
+	}
+	return sessionID, accessToken, refreshToken, nil
+}
+
+// RefreshToken exchanges a refresh token for a new access token and a new
+// refresh token. The presented token is revoked, so each refresh token can
+// only be used once. Presenting a token that was already rotated is treated
+// as theft and revokes every token in its family.
 func (h *AuthHandler) RefreshToken(w http.ResponseWriter, r *http.Request) {
 	type response struct {
-		Token string `json:"token"`
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
 	}
 
 	refreshToken, err := auth.GetBearerToken(r.Header)
@@ -105,7 +371,50 @@ func (h *AuthHandler) RefreshToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := h.db.GetUserFromRefreshToken(r.Context(), refreshToken)
+	newRefreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Couldn't create refresh token")
+		return
+	}
+
+	// Revoking the old token and saving its replacement happen together, so
+	// a failed save can't leave the session without a usable token.
+	var oldToken database.RefreshToken
+	err = h.db.InTx(r.Context(), func(q *database.Queries) error {
+		var err error
+		oldToken, err = q.RotateRefreshToken(r.Context(), database.RotateRefreshTokenParams{
+			Token:      refreshToken,
+			ReplacedBy: sql.NullString{String: newRefreshToken, Valid: true},
+		})
+		if err != nil {
+			return err
+		}
+
+		_, err = q.CreateRefreshToken(r.Context(), database.CreateRefreshTokenParams{
+			UserID:    oldToken.UserID,
+			Token:     newRefreshToken,
+			ExpiresAt: time.Now().UTC().Add(refreshTokenTTL),
+			FamilyID:  oldToken.FamilyID,
+			// The session keeps its device, but the address may have changed.
+			UserAgent:   oldToken.UserAgent,
+			IpAddress:   utils.ClientIP(r),
+			DeviceLabel: oldToken.DeviceLabel,
+		})
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			h.detectRefreshTokenReuse(r, refreshToken)
+			utils.RespondWithError(w, http.StatusUnauthorized, "Couldn't get user for refresh token")
+			return
+		}
+		log.Printf("Error rotating refresh token: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Couldn't rotate refresh token")
+		return
+	}
+
+	// Look the role up again so role changes apply from the next refresh.
+	user, err := h.db.GetUserByID(r.Context(), oldToken.UserID)
 	if err != nil {
 		utils.RespondWithError(w, http.StatusUnauthorized, "Couldn't get user for refresh token")
 		return
@@ -113,19 +422,41 @@ func (h *AuthHandler) RefreshToken(w http.ResponseWriter, r *http.Request) {
 
 	accessToken, err := auth.MakeJWT(
 		user.ID,
-		h.jwtSecret,
+		h.keys,
 		time.Hour,
+		auth.WithSessionID(oldToken.FamilyID),
+		auth.WithRole(auth.Role(user.Role)),
 	)
 	if err != nil {
 		utils.RespondWithError(w, http.StatusUnauthorized, "Couldn't validate token")
 		return
 	}
+	recordSecurityEvent(r, h.db, user.ID, securityEventTokenRefreshed, "session "+oldToken.FamilyID.String())
 
 	utils.RespondWithJSON(w, http.StatusOK, response{
-		Token: accessToken,
+		Token:        accessToken,
+		RefreshToken: newRefreshToken,
 	})
 }
 
+// detectRefreshTokenReuse revokes the whole family of a refresh token that
+// was presented after it had already been rotated. Tokens revoked by logging
+// out, signing out a session or resetting the password have no replacement
+// and are simply refused.
+func (h *AuthHandler) detectRefreshTokenReuse(r *http.Request, refreshToken string) {
+	token, err := h.db.GetRefreshToken(r.Context(), refreshToken)
+	if err != nil || !token.ReplacedBy.Valid {
+		return
+	}
+
+	log.Printf("Refresh token reuse detected for user %s (family %s), possible token theft; revoking family", token.UserID, token.FamilyID)
+	recordSecurityEvent(r, h.db, token.UserID, securityEventRefreshTokenReused, "session "+token.FamilyID.String()+" revoked")
+	err = h.db.RevokeRefreshTokenFamily(r.Context(), token.FamilyID)
+	if err != nil {
+		log.Printf("Error revoking refresh token family %s: %s", token.FamilyID, err)
+	}
+}
+
 func (h *AuthHandler) RevokeToken(w http.ResponseWriter, r *http.Request) {
 	refreshToken, err := auth.GetBearerToken(r.Header)
 	if err != nil {
@@ -133,11 +464,12 @@ func (h *AuthHandler) RevokeToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = h.db.RevokeRefreshToken(r.Context(), refreshToken)
+	token, err := h.db.RevokeRefreshToken(r.Context(), refreshToken)
 	if err != nil {
 		utils.RespondWithError(w, http.StatusInternalServerError, "Couldn't revoke session")
 		return
 	}
+	recordSecurityEvent(r, h.db, token.UserID, securityEventSessionRevoked, "session "+token.FamilyID.String())
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yujen77300/Chirpy-Server/internal/database"
+	"github.com/yujen77300/Chirpy-Server/internal/utils"
+)
+
+// ChirpEditPolicy says how long after posting a chirp its author can edit
+// it.
+type ChirpEditPolicy struct {
+	Window time.Duration
+	// RedWindow applies to Chirpy Red members instead of Window.
+	RedWindow time.Duration
+}
+
+var DefaultChirpEditPolicy = ChirpEditPolicy{
+	Window:    time.Minute * 15,
+	RedWindow: time.Hour,
+}
+
+func (p ChirpEditPolicy) window(user database.User) time.Duration {
+	if user.IsChirpyRed {
+		return p.RedWindow
+	}
+	return p.Window
+}
+
+type chirpRevisionResponse struct {
+	Body       string    `json:"body"`
+	CreatedAt  time.Time `json:"created_at"`
+	ReplacedAt time.Time `json:"replaced_at"`
+}
+
+// Update replaces the body of a chirp. Only its author can edit it, and only
+// within the edit window. The previous body is kept in the chirp's history.
+func (h *ChirpsHandler) Update(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
+	}
+
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	chirp, err := h.db.GetChirp(r.Context(), id)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Chirp not found")
+		return
+	}
+	if chirp.UserID != principal.UserID {
+		utils.RespondWithError(w, http.StatusForbidden, "You cannot edit another user's chirp")
+		return
+	}
+
+	user, err := h.db.GetUserByID(r.Context(), principal.UserID)
+	if err != nil {
+		log.Printf("Error getting user: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+	window := h.edits.window(user)
+	if time.Since(chirp.CreatedAt) > window {
+		utils.RespondWithError(w, http.StatusForbidden, fmt.Sprintf("Chirps can only be edited for %s after posting", window))
+		return
+	}
+
+	type parameters struct {
+		Body string `json:"body"`
+	}
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	body, ok := cleanChirpBody(w, params.Body)
+	if !ok {
+		return
+	}
+	if body == chirp.Body {
+		// Nothing changed, so there is no revision to keep.
+		utils.RespondWithJSON(w, http.StatusOK, newChirpResponse(chirp))
+		return
+	}
+
+	chirp, err = h.db.UpdateChirpBody(r.Context(), database.UpdateChirpBodyParams{
+		ID:   chirp.ID,
+		Body: body,
+	})
+	if err != nil {
+		log.Printf("Error updating chirp: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, newChirpResponse(chirp))
+}
+
+// History returns the earlier bodies of a chirp, most recently replaced
+// first. Chirps that were never edited have an empty history.
+func (h *ChirpsHandler) History(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
+	}
+
+	chirp, err := h.db.GetChirp(r.Context(), id)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Chirp not found")
+		return
+	}
+
+	revisions, err := h.db.GetChirpRevisions(r.Context(), chirp.ID)
+	if err != nil {
+		log.Printf("Error getting chirp revisions: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	response := []chirpRevisionResponse{}
+	for _, revision := range revisions {
+		response = append(response, chirpRevisionResponse{
+			Body:       revision.Body,
+			CreatedAt:  revision.CreatedAt,
+			ReplacedAt: revision.ReplacedAt,
+		})
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"slices"
+
+	"github.com/google/uuid"
+	"github.com/yujen77300/Chirpy-Server/internal/database"
+	"github.com/yujen77300/Chirpy-Server/internal/utils"
+)
+
+// maxThreadSize is the most chirps a thread view holds. Replies are gathered
+// a level at a time up to that many and the oldest are kept, so the
+// ancestors and the parent of every reply shown are always there.
+const maxThreadSize = 500
+
+// threadChirp is a chirp as part of a conversation. Deleted chirps, and
+// those of deactivated accounts, are tombstones: they keep their place in
+// the conversation but have no content.
+type threadChirp struct {
+	ID             uuid.UUID  `json:"id"`
+	InReplyTo      *uuid.UUID `json:"in_reply_to,omitempty"`
+	ConversationID uuid.UUID  `json:"conversation_id"`
+	Deleted        bool       `json:"deleted,omitempty"`
+	*chirpResponse
+	Replies []*threadChirp `json:"replies,omitempty"`
+}
+
+type threadResponse struct {
+	Ancestors []*threadChirp `json:"ancestors"`
+	Chirp     *threadChirp   `json:"chirp"`
+	// Truncated is set when the thread had more than maxThreadSize chirps
+	// and the newest replies were left out.
+	Truncated bool `json:"truncated"`
+}
+
+func newThreadChirp(row database.GetChirpThreadRow) *threadChirp {
+	c := &threadChirp{
+		ID:             row.ID,
+		InReplyTo:      replyTo(row.InReplyTo),
+		ConversationID: conversationID(row.ID, row.RootID),
+	}
+	if row.DeletedAt.Valid || row.AuthorDeactivated {
+		c.Deleted = true
+		return c
+	}
+	response := newChirpResponse(database.Chirp{
+		ID:        row.ID,
+		CreatedAt: row.CreatedAt,
+		UpdatedAt: row.UpdatedAt,
+		Body:      row.Body,
+		UserID:    row.UserID,
+		Lang:      row.Lang,
+		InReplyTo: row.InReplyTo,
+		RootID:    row.RootID,
+	})
+	c.chirpResponse = &response
+	return c
+}
+
+func replyTo(inReplyTo uuid.NullUUID) *uuid.UUID {
+	if !inReplyTo.Valid {
+		return nil
+	}
+	return &inReplyTo.UUID
+}
+
+func conversationID(id uuid.UUID, rootID uuid.NullUUID) uuid.UUID {
+	if rootID.Valid {
+		return rootID.UUID
+	}
+	return id
+}
+
+// Replies returns a page of the direct replies to a chirp, oldest first.
+// Pages hold up to limit replies (50 by default, at most 100) and the Link
+// header has the URL of the next one.
+func (h *ChirpsHandler) Replies(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
+	}
+
+	limit, ok := parseLimit(w, r, 50, 100)
+	if !ok {
+		return
+	}
+
+	// One more row than needed tells whether another page follows.
+	params := database.ListRepliesAfterParams{
+		ParentID: id,
+		RowLimit: int32(limit + 1),
+	}
+	if s := r.URL.Query().Get("cursor"); s != "" {
+		cursor, err := decodePageCursor(s)
+		if err != nil || cursor.Before {
+			utils.RespondWithError(w, http.StatusBadRequest, "Invalid cursor")
+			return
+		}
+		params.CursorCreatedAt = sql.NullTime{Time: cursor.CreatedAt, Valid: true}
+		params.CursorID = uuid.NullUUID{UUID: cursor.ID, Valid: true}
+	}
+
+	// Tombstones can have replies too, so the chirp only has to exist.
+	exists, err := h.db.ChirpExists(r.Context(), id)
+	if err != nil {
+		log.Printf("Error getting chirp: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+	if !exists {
+		utils.RespondWithError(w, http.StatusNotFound, "Chirp not found")
+		return
+	}
+
+	rows, err := h.db.ListRepliesAfter(r.Context(), params)
+	if err != nil {
+		log.Printf("Error getting replies: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	if len(rows) > limit {
+		rows = rows[:limit]
+		last := rows[len(rows)-1]
+		setPageLinks(w, r, encodeCursor(pageCursor{CreatedAt: last.CreatedAt, ID: last.ID}), "")
+	}
+
+	replies := []*threadChirp{}
+	for _, row := range rows {
+		replies = append(replies, newThreadChirp(database.GetChirpThreadRow(row)))
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, replies)
+}
+
+// Thread returns the conversation around a chirp: the chain of chirps it
+// replies to, from the start of the conversation down, and the chirp with
+// every reply below it as a tree. Replies to the ancestors that aren't on
+// the way to the chirp are left out.
+func (h *ChirpsHandler) Thread(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
+	}
+
+	rows, err := h.db.GetChirpThread(r.Context(), database.GetChirpThreadParams{
+		ChirpID:  id,
+		RowLimit: maxThreadSize + 1,
+	})
+	if err != nil {
+		log.Printf("Error getting thread: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+	truncated := len(rows) > maxThreadSize
+	if truncated {
+		rows = rows[:maxThreadSize]
+	}
+
+	chirps := make(map[uuid.UUID]*threadChirp, len(rows))
+	for _, row := range rows {
+		chirps[row.ID] = newThreadChirp(row)
+	}
+	chirp, ok := chirps[id]
+	if !ok {
+		utils.RespondWithError(w, http.StatusNotFound, "Chirp not found")
+		return
+	}
+
+	ancestors := []*threadChirp{}
+	inAncestors := map[uuid.UUID]bool{}
+	for c := chirp; c.InReplyTo != nil; {
+		parent, ok := chirps[*c.InReplyTo]
+		if !ok {
+			break
+		}
+		ancestors = append(ancestors, parent)
+		inAncestors[parent.ID] = true
+		c = parent
+	}
+	slices.Reverse(ancestors)
+
+	// Rows come oldest first, so replies end up in the order they were
+	// posted.
+	for _, row := range rows {
+		if row.ID == id || inAncestors[row.ID] {
+			continue
+		}
+		reply := chirps[row.ID]
+		if parent, ok := chirps[row.InReplyTo.UUID]; ok && row.InReplyTo.Valid {
+			parent.Replies = append(parent.Replies, reply)
+		}
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, threadResponse{
+		Ancestors: ancestors,
+		Chirp:     chirp,
+		Truncated: truncated,
+	})
+}
@@ -1,29 +1,34 @@
 package handlers
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"slices"
-	"sort"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/yujen77300/Chirpy-Server/internal/auth"
 	"github.com/yujen77300/Chirpy-Server/internal/database"
+	"github.com/yujen77300/Chirpy-Server/internal/search"
 	"github.com/yujen77300/Chirpy-Server/internal/utils"
 )
 
 type ChirpsHandler struct {
-	db        *database.Queries
-	jwtSecret string
+	db           *database.Queries
+	verification VerificationPolicy
+	edits        ChirpEditPolicy
 }
 
-func NewChirpsHandler(db *database.Queries, jwtSecret string) *ChirpsHandler {
+func NewChirpsHandler(db *database.Queries, verification VerificationPolicy, edits ChirpEditPolicy) *ChirpsHandler {
 	return &ChirpsHandler{
-		db:        db,
-		jwtSecret: jwtSecret,
+		db:           db,
+		verification: verification,
+		edits:        edits,
 	}
 }
 
@@ -33,24 +38,55 @@ type chirpResponse struct {
 	UpdatedAt time.Time `json:"updated_at"`
 	Body      string    `json:"body"`
 	UserID    uuid.UUID `json:"user_id"`
+	Lang      string    `json:"lang,omitempty"`
+	// Edited is set once the body has been changed since posting.
+	Edited    bool       `json:"edited"`
+	InReplyTo *uuid.UUID `json:"in_reply_to,omitempty"`
+	// ConversationID is the ID of the chirp that started the conversation,
+	// which is the chirp's own ID unless it is a reply.
+	ConversationID uuid.UUID `json:"conversation_id"`
+}
+
+func newChirpResponse(chirp database.Chirp) chirpResponse {
+	return chirpResponse{
+		ID:             chirp.ID,
+		CreatedAt:      chirp.CreatedAt,
+		UpdatedAt:      chirp.UpdatedAt,
+		Body:           chirp.Body,
+		UserID:         chirp.UserID,
+		Lang:           chirp.Lang.String,
+		Edited:         chirp.UpdatedAt.After(chirp.CreatedAt),
+		InReplyTo:      replyTo(chirp.InReplyTo),
+		ConversationID: conversationID(chirp.ID, chirp.RootID),
+	}
 }
 
 // Create handles the creation of new chirps
 func (h *ChirpsHandler) Create(w http.ResponseWriter, r *http.Request) {
-	tokenString, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		utils.RespondWithError(w, http.StatusUnauthorized, "Missing or malformed token")
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
 		return
 	}
+	userID := principal.UserID
 
-	userID, err := auth.ValidateJWT(tokenString, h.jwtSecret)
-	if err != nil {
-		utils.RespondWithError(w, http.StatusUnauthorized, "Invalid token")
-		return
+	if h.verification.BlockChirps {
+		user, err := h.db.GetUserByID(r.Context(), userID)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusUnauthorized, "Couldn't find user")
+			return
+		}
+		if !user.EmailVerifiedAt.Valid {
+			utils.RespondWithError(w, http.StatusForbidden, "Verify your email address before posting chirps")
+			return
+		}
 	}
 
 	var params struct {
 		Body string `json:"body"`
+		// Lang is an optional ISO 639-1 code, used by the lang: search
+		// operator.
+		Lang      string     `json:"lang"`
+		InReplyTo *uuid.UUID `json:"in_reply_to"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
@@ -59,17 +95,37 @@ func (h *ChirpsHandler) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if len(params.Body) > 140 {
-		utils.RespondWithError(w, http.StatusBadRequest, "Chirp is too long")
+	cleanedBody, ok := cleanChirpBody(w, params.Body)
+	if !ok {
 		return
 	}
 
-	cleanedBody := replaceProfaneWords(params.Body)
+	if params.Lang != "" && !search.IsLanguageCode(params.Lang) {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid language code")
+		return
+	}
 
-	chirp, err := h.db.CreateChirp(r.Context(), database.CreateChirpParams{
+	createParams := database.CreateChirpParams{
 		Body:   cleanedBody,
 		UserID: userID,
-	})
+		Lang:   sql.NullString{String: params.Lang, Valid: params.Lang != ""},
+	}
+	if params.InReplyTo != nil {
+		parent, err := h.db.GetChirp(r.Context(), *params.InReplyTo)
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.RespondWithError(w, http.StatusNotFound, "Chirp to reply to not found")
+			return
+		}
+		if err != nil {
+			log.Printf("Error getting chirp: %s", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+			return
+		}
+		createParams.InReplyTo = uuid.NullUUID{UUID: parent.ID, Valid: true}
+		createParams.RootID = uuid.NullUUID{UUID: conversationID(parent.ID, parent.RootID), Valid: true}
+	}
+
+	chirp, err := h.db.CreateChirp(r.Context(), createParams)
 
 	if err != nil {
 		log.Printf("Error creating chirp: %s", err)
@@ -77,62 +133,123 @@ func (h *ChirpsHandler) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	utils.RespondWithJSON(w, http.StatusCreated, chirpResponse{
-		ID:        chirp.ID,
-		CreatedAt: chirp.CreatedAt,
-		UpdatedAt: chirp.UpdatedAt,
-		Body:      chirp.Body,
-		UserID:    chirp.UserID,
-	})
+	utils.RespondWithJSON(w, http.StatusCreated, newChirpResponse(chirp))
 }
 
-// GetAll returns all chirps, with optional filtering
+// GetAll returns a page of chirps, oldest first or newest first with
+// sort=desc, optionally only those by author_id or matching the search
+// query q (see package search). Pages hold up to limit chirps (50 by
+// default, at most 100). The Link header has the URLs of the next and
+// previous pages, which carry an opaque cursor.
 func (h *ChirpsHandler) GetAll(w http.ResponseWriter, r *http.Request) {
-	authorIDStr := r.URL.Query().Get("author_id")
-	sortOrder := r.URL.Query().Get("sort")
+	query := r.URL.Query()
+	descending := query.Get("sort") == "desc"
 
-	var chirps []database.Chirp
-	var err error
+	var filter search.Node
+	if q := query.Get("q"); q != "" {
+		if len(q) > maxSearchQueryLength {
+			utils.RespondWithError(w, http.StatusBadRequest, "Search query is too long")
+			return
+		}
+		var err error
+		filter, err = search.Parse(q)
+		if err != nil {
+			var queryErr *search.Error
+			if !errors.As(err, &queryErr) {
+				log.Printf("Error parsing search query: %s", err)
+				utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+				return
+			}
+			utils.RespondWithValidationErrors(w, []utils.FieldError{{
+				Field:   "q",
+				Code:    "invalid_query",
+				Message: queryErr.Error(),
+			}})
+			return
+		}
+	}
+
+	limit, ok := parseLimit(w, r, 50, 100)
+	if !ok {
+		return
+	}
 
-	if authorIDStr != "" {
-		authorID, parseErr := uuid.Parse(authorIDStr)
-		if parseErr != nil {
+	// One more row than needed tells whether another page follows.
+	params := database.ListChirpsAfterParams{
+		RowLimit: int32(limit + 1),
+	}
+
+	if authorIDStr := query.Get("author_id"); authorIDStr != "" {
+		authorID, err := uuid.Parse(authorIDStr)
+		if err != nil {
 			log.Printf("Invalid author ID: %s", err)
 			utils.RespondWithError(w, http.StatusBadRequest, "Invalid author ID")
 			return
 		}
+		params.AuthorID = uuid.NullUUID{UUID: authorID, Valid: true}
+	}
 
-		chirps, err = h.db.GetChirpsByAuthorID(r.Context(), authorID)
-	} else {
-		chirps, err = h.db.GetChirps(r.Context())
+	var cursor *pageCursor
+	if s := query.Get("cursor"); s != "" {
+		c, err := decodePageCursor(s)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "Invalid cursor")
+			return
+		}
+		cursor = &c
+		params.CursorCreatedAt = sql.NullTime{Time: c.CreatedAt, Valid: true}
+		params.CursorID = uuid.NullUUID{UUID: c.ID, Valid: true}
 	}
 
+	// A previous page is read walking away from the cursor too, so it comes
+	// back in reverse and is flipped afterwards.
+	backwards := cursor != nil && cursor.Before
+	var chirps []database.Chirp
+	var err error
+	switch {
+	case filter != nil:
+		// The filter's placeholders follow the four of the listing.
+		cond, args := search.Compile(filter, 5)
+		chirps, err = h.db.ListFilteredChirps(r.Context(), database.ListFilteredChirpsParams{
+			ListChirpsAfterParams: params,
+			Filter:                cond,
+			FilterArgs:            args,
+			Descending:            descending != backwards,
+		})
+	case descending != backwards:
+		chirps, err = h.db.ListChirpsBefore(r.Context(), database.ListChirpsBeforeParams(params))
+	default:
+		chirps, err = h.db.ListChirpsAfter(r.Context(), params)
+	}
 	if err != nil {
 		log.Printf("Error getting chirps: %s", err)
 		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
 		return
 	}
 
+	more := len(chirps) > limit
+	if more {
+		chirps = chirps[:limit]
+	}
+	if backwards {
+		slices.Reverse(chirps)
+	}
 
-	if sortOrder == "desc" {
-		sort.Slice(chirps, func(i, j int) bool {
-			return chirps[i].CreatedAt.After(chirps[j].CreatedAt)
-		})
-	} else {
-		sort.Slice(chirps, func(i, j int) bool {
-			return chirps[i].CreatedAt.Before(chirps[j].CreatedAt)
-		})
+	if len(chirps) > 0 {
+		var next, prev string
+		first, last := chirps[0], chirps[len(chirps)-1]
+		if more || backwards {
+			next = encodeCursor(pageCursor{CreatedAt: last.CreatedAt, ID: last.ID})
+		}
+		if (more && backwards) || (cursor != nil && !backwards) {
+			prev = encodeCursor(pageCursor{CreatedAt: first.CreatedAt, ID: first.ID, Before: true})
+		}
+		setPageLinks(w, r, next, prev)
 	}
 
-	var chirpResponses []chirpResponse
+	chirpResponses := []chirpResponse{}
 	for _, chirp := range chirps {
-		chirpResponses = append(chirpResponses, chirpResponse{
-			ID:        chirp.ID,
-			CreatedAt: chirp.CreatedAt,
-			UpdatedAt: chirp.UpdatedAt,
-			Body:      chirp.Body,
-			UserID:    chirp.UserID,
-		})
+		chirpResponses = append(chirpResponses, newChirpResponse(chirp))
 	}
 
 	utils.RespondWithJSON(w, http.StatusOK, chirpResponses)
@@ -155,16 +272,12 @@ func (h *ChirpsHandler) GetByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	utils.RespondWithJSON(w, http.StatusOK, chirpResponse{
-		ID:        chirp.ID,
-		CreatedAt: chirp.CreatedAt,
-		UpdatedAt: chirp.UpdatedAt,
-		Body:      chirp.Body,
-		UserID:    chirp.UserID,
-	})
+	utils.RespondWithJSON(w, http.StatusOK, newChirpResponse(chirp))
 }
 
-// Delete removes a chirp if the user is the author
+// Delete removes a chirp if the user is the author or a moderator. A chirp
+// with replies is emptied and left as a tombstone, so its replies stay in
+// their conversation, until the last of them is deleted.
 func (h *ChirpsHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	chirpIDStr := r.PathValue("chirpID")
 
@@ -175,17 +288,11 @@ func (h *ChirpsHandler) Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tokenString, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		utils.RespondWithError(w, http.StatusUnauthorized, "Missing or malformed token")
-		return
-	}
-
-	userID, err := auth.ValidateJWT(tokenString, h.jwtSecret)
-	if err != nil {
-		utils.RespondWithError(w, http.StatusUnauthorized, "Invalid token")
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
 		return
 	}
+	userID := principal.UserID
 
 	chirp, err := h.db.GetChirp(r.Context(), id)
 	if err != nil {
@@ -193,13 +300,16 @@ func (h *ChirpsHandler) Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if chirp.UserID != userID {
+	if chirp.UserID != userID && !principal.Role.Can(auth.PermissionDeleteAnyChirp) {
 		utils.RespondWithError(w, http.StatusForbidden, "You cannot delete another user's chirp")
 		return
 	}
 
-	err = h.db.DeleteChirp(r.Context(), chirp.ID)
+	err = h.db.InTx(r.Context(), func(q *database.Queries) error {
+		return deleteChirp(r.Context(), q, chirp.ID)
+	})
 	if err != nil {
+		log.Printf("Error deleting chirp: %s", err)
 		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
 		return
 	}
@@ -207,6 +317,51 @@ func (h *ChirpsHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	utils.RespondWithJSON(w, http.StatusNoContent, nil)
 }
 
+// deleteChirp deletes a chirp, or leaves a tombstone if it has replies. A
+// tombstone left without replies is deleted too, and so on up the
+// conversation. Each chirp is locked first, so a reply can't be added between
+// the check for replies and the delete.
+func deleteChirp(ctx context.Context, q *database.Queries, id uuid.UUID) error {
+	err := q.LockChirp(ctx, id)
+	if err != nil {
+		return err
+	}
+	tombstoned, err := q.TombstoneChirp(ctx, id)
+	if err != nil || tombstoned > 0 {
+		return err
+	}
+	parent, err := q.DeleteChirp(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	for parent.Valid {
+		err = q.LockChirp(ctx, parent.UUID)
+		if err != nil {
+			return err
+		}
+		parent, err = q.DeleteEmptyTombstone(ctx, parent.UUID)
+		if errors.Is(err, sql.ErrNoRows) {
+			// The parent isn't a tombstone or still has replies.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cleanChirpBody checks the length of a new or edited chirp body and
+// filters its profanity.
+func cleanChirpBody(w http.ResponseWriter, body string) (string, bool) {
+	if len(body) > 140 {
+		utils.RespondWithError(w, http.StatusBadRequest, "Chirp is too long")
+		return "", false
+	}
+	return replaceProfaneWords(body), true
+}
+
 // Helper function for profanity filtering
 func replaceProfaneWords(body string) string {
 	profaneWords := []string{"kerfuffle", "sharbert", "fornax"}
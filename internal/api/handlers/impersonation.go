@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yujen77300/Chirpy-Server/internal/auth"
+	"github.com/yujen77300/Chirpy-Server/internal/database"
+	"github.com/yujen77300/Chirpy-Server/internal/utils"
+)
+
+// Impersonation tokens expire after 15 minutes and can't be refreshed.
+const impersonationTTL = time.Minute * 15
+
+type auditLogEntryResponse struct {
+	ID        uuid.UUID `json:"id"`
+	Action    string    `json:"action"`
+	ActorID   uuid.UUID `json:"actor_id"`
+	SubjectID uuid.UUID `json:"subject_id"`
+	Details   string    `json:"details"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Impersonate issues the calling admin a short-lived access token for
+// another user. The token names the admin in its act claim, is refused for
+// changes to the account, and everything done with it is audited.
+func (h *AdminHandler) Impersonate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Reason string `json:"reason"`
+	}
+	type response struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	admin, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	adminID := admin.UserID
+
+	params := parameters{}
+	err = json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		log.Printf("Error decoding JSON: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+	params.Reason = strings.TrimSpace(params.Reason)
+	if params.Reason == "" || len(params.Reason) > 500 {
+		utils.RespondWithError(w, http.StatusBadRequest, "A reason of up to 500 characters is required")
+		return
+	}
+	if userID == adminID {
+		utils.RespondWithError(w, http.StatusBadRequest, "You can't impersonate yourself")
+		return
+	}
+
+	user, err := h.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.RespondWithError(w, http.StatusNotFound, "User not found")
+			return
+		}
+		log.Printf("Error getting user: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	// Write the trail before handing out the token.
+	err = h.db.CreateAuditLogEntry(r.Context(), database.CreateAuditLogEntryParams{
+		Action:    "impersonation.start",
+		ActorID:   adminID,
+		SubjectID: user.ID,
+		Details:   params.Reason,
+	})
+	if err != nil {
+		log.Printf("Error writing audit log: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	expiresAt := time.Now().UTC().Add(impersonationTTL)
+	token, err := auth.MakeJWT(user.ID, h.keys, impersonationTTL, auth.WithRole(auth.Role(user.Role)), auth.WithActor(adminID))
+	if err != nil {
+		log.Printf("Error creating impersonation token: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusCreated, response{
+		Token:     token,
+		ExpiresAt: expiresAt,
+	})
+}
+
+// GetAuditLog returns the most recent audit log entries, newest first. The
+// limit query parameter defaults to 100 and is capped at 1000.
+func (h *AdminHandler) GetAuditLog(w http.ResponseWriter, r *http.Request) {
+	limit, ok := parseLimit(w, r, 100, 1000)
+	if !ok {
+		return
+	}
+
+	entries, err := h.db.GetAuditLog(r.Context(), int32(limit))
+	if err != nil {
+		log.Printf("Error getting audit log: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	resp := []auditLogEntryResponse{}
+	for _, entry := range entries {
+		resp = append(resp, auditLogEntryResponse{
+			ID:        entry.ID,
+			Action:    entry.Action,
+			ActorID:   entry.ActorID,
+			SubjectID: entry.SubjectID,
+			Details:   entry.Details,
+			CreatedAt: entry.CreatedAt,
+		})
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, resp)
+}
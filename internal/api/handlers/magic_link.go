@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/yujen77300/Chirpy-Server/internal/auth"
+	"github.com/yujen77300/Chirpy-Server/internal/database"
+	"github.com/yujen77300/Chirpy-Server/internal/lockout"
+	"github.com/yujen77300/Chirpy-Server/internal/mailer"
+	"github.com/yujen77300/Chirpy-Server/internal/utils"
+)
+
+// Login links are valid for 15 minutes.
+const magicLinkTTL = time.Minute * 15
+
+// MagicLinkHandler logs users in with a single-use link sent to their email
+// address instead of a password.
+type MagicLinkHandler struct {
+	db      *database.Queries
+	mailer  mailer.Mailer
+	baseURL string
+	limiter *lockout.Guard
+	auth    *AuthHandler
+}
+
+func NewMagicLinkHandler(db *database.Queries, mailer mailer.Mailer, baseURL string, limiter *lockout.Guard, authHandler *AuthHandler) *MagicLinkHandler {
+	return &MagicLinkHandler{
+		db:      db,
+		mailer:  mailer,
+		baseURL: baseURL,
+		limiter: limiter,
+		auth:    authHandler,
+	}
+}
+
+// Request emails a login link. Like a password reset it answers the same way
+// whether or not the email belongs to an account. Requests are rate limited
+// per email address and client IP.
+func (h *MagicLinkHandler) Request(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+	}
+
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		log.Printf("Error decoding JSON: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+	if !isValidEmail(params.Email) {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid email address")
+		return
+	}
+
+	limitKeys := []string{lockout.AccountKey(params.Email), lockout.IPKey(utils.ClientIP(r))}
+	wait, err := h.limiter.Check(r.Context(), limitKeys...)
+	if err != nil {
+		log.Printf("Error checking login link requests: %s", err)
+	}
+	if wait > 0 {
+		respondWithRetryAfter(w, http.StatusTooManyRequests, "Too many login links requested, try again later", wait)
+		return
+	}
+	_, err = h.limiter.RecordFailure(r.Context(), limitKeys...)
+	if err != nil {
+		log.Printf("Error recording login link request: %s", err)
+	}
+
+	user, err := h.db.GetUserByEmail(r.Context(), params.Email)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Error getting user for login link: %s", err)
+		}
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	token, err := auth.MakeOpaqueToken()
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Couldn't create login link")
+		return
+	}
+
+	err = h.db.CreateMagicLinkToken(r.Context(), database.CreateMagicLinkTokenParams{
+		TokenHash: auth.HashToken(token),
+		ExpiresAt: time.Now().UTC().Add(magicLinkTTL),
+		UserID:    user.ID,
+	})
+	if err != nil {
+		log.Printf("Error saving login link token: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	msg := mailer.Message{
+		To:      user.Email,
+		Subject: "Your Chirpy login link",
+		Body: fmt.Sprintf("Use this link within the next 15 minutes to log in to Chirpy:\n%s/login/magic?token=%s\n\n"+
+			"The link works once. If you didn't ask for it, you can ignore this email.\n",
+			h.baseURL, url.QueryEscape(token)),
+	}
+	// Send in the background so response times don't reveal which emails exist.
+	go func() {
+		if err := h.mailer.Send(context.Background(), msg); err != nil {
+			log.Printf("Error sending login link email: %s", err)
+		}
+	}()
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Redeem exchanges a login link token for the same tokens as a password
+// login. Accounts with two-factor authentication still need their code.
+func (h *MagicLinkHandler) Redeem(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token       string `json:"token"`
+		DeviceLabel string `json:"device_label"`
+	}
+
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		log.Printf("Error decoding JSON: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	linkToken, err := h.db.UseMagicLinkToken(r.Context(), auth.HashToken(params.Token))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.RespondWithError(w, http.StatusUnauthorized, "Invalid or expired login link")
+			return
+		}
+		log.Printf("Error using login link token: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	user, err := h.db.GetUserByID(r.Context(), linkToken.UserID)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Couldn't find user")
+		return
+	}
+
+	h.auth.completeFirstFactor(w, r, user, params.DeviceLabel)
+}
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/yujen77300/Chirpy-Server/internal/auth"
+	"github.com/yujen77300/Chirpy-Server/internal/database"
+	"github.com/yujen77300/Chirpy-Server/internal/utils"
+)
+
+const (
+	totpIssuer        = "Chirpy"
+	recoveryCodeCount = 10
+)
+
+type MFAHandler struct {
+	db *database.Queries
+}
+
+func NewMFAHandler(db *database.Queries) *MFAHandler {
+	return &MFAHandler{
+		db: db,
+	}
+}
+
+// EnrollTOTP generates a new TOTP secret for the user. It stays pending
+// until it is confirmed with a code from the authenticator app.
+func (h *MFAHandler) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Secret          string `json:"secret"`
+		ProvisioningURI string `json:"provisioning_uri"`
+	}
+
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID := principal.UserID
+
+	user, err := h.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Couldn't find user")
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Couldn't generate TOTP secret")
+		return
+	}
+
+	_, err = h.db.UpsertPendingTOTPCredential(r.Context(), database.UpsertPendingTOTPCredentialParams{
+		UserID: user.ID,
+		Secret: secret,
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.RespondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled")
+			return
+		}
+		log.Printf("Error saving TOTP secret: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, response{
+		Secret:          secret,
+		ProvisioningURI: auth.TOTPProvisioningURI(secret, totpIssuer, user.Email),
+	})
+}
+
+// ConfirmTOTP enables two-factor authentication once the user proves their
+// authenticator works, and hands out a fresh set of recovery codes.
+func (h *MFAHandler) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Code string `json:"code"`
+	}
+	type response struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID := principal.UserID
+
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		log.Printf("Error decoding JSON: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	totp, err := h.db.GetTOTPCredential(r.Context(), userID)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "No pending two-factor enrollment")
+		return
+	}
+	if totp.ConfirmedAt.Valid {
+		utils.RespondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled")
+		return
+	}
+
+	step, ok := auth.ValidateTOTP(params.Code, totp.Secret, time.Now())
+	if !ok {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Invalid code")
+		return
+	}
+
+	recoveryCodes, err := auth.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Couldn't generate recovery codes")
+		return
+	}
+
+	err = h.db.DeleteRecoveryCodes(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error deleting recovery codes: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+	for _, code := range recoveryCodes {
+		err = h.db.CreateRecoveryCode(r.Context(), database.CreateRecoveryCodeParams{
+			CodeHash: auth.HashToken(code),
+			UserID:   userID,
+		})
+		if err != nil {
+			log.Printf("Error saving recovery code: %s", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+			return
+		}
+	}
+
+	_, err = h.db.ConfirmTOTPCredential(r.Context(), database.ConfirmTOTPCredentialParams{
+		Step:   step,
+		UserID: userID,
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.RespondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled")
+			return
+		}
+		log.Printf("Error confirming TOTP: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, response{
+		RecoveryCodes: recoveryCodes,
+	})
+}
@@ -0,0 +1,590 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yujen77300/Chirpy-Server/internal/auth"
+	"github.com/yujen77300/Chirpy-Server/internal/database"
+	"github.com/yujen77300/Chirpy-Server/internal/lockout"
+	"github.com/yujen77300/Chirpy-Server/internal/utils"
+)
+
+const (
+	oauthAccessTokenTTL  = time.Hour
+	oauthRefreshTokenTTL = time.Hour * 24 * 30
+	// Authorization codes are exchanged right after the redirect, so they
+	// only need to live for a moment.
+	oauthCodeTTL = time.Minute
+)
+
+// scopeDescriptions is what the consent screen shows for each scope.
+var scopeDescriptions = map[string]string{
+	auth.ScopeChirpsRead:  "Read chirps",
+	auth.ScopeChirpsWrite: "Post and delete chirps as you",
+}
+
+// OAuthHandler makes Chirpy an OAuth 2.1 authorization server, so third-party
+// clients can act for a user without ever seeing their password.
+type OAuthHandler struct {
+	db    *database.Queries
+	keys  *auth.KeySet
+	guard *lockout.Guard
+}
+
+func NewOAuthHandler(db *database.Queries, keys *auth.KeySet, guard *lockout.Guard) *OAuthHandler {
+	return &OAuthHandler{
+		db:    db,
+		keys:  keys,
+		guard: guard,
+	}
+}
+
+// authorizeRequest is a validated request to /oauth/authorize.
+type authorizeRequest struct {
+	Client        database.OauthClient
+	RedirectURI   string
+	Scope         string
+	State         string
+	CodeChallenge string
+}
+
+// Authorize shows the consent screen for an authorization request.
+func (h *OAuthHandler) Authorize(w http.ResponseWriter, r *http.Request) {
+	req, ok := h.parseAuthorizeRequest(w, r, r.URL.Query())
+	if !ok {
+		return
+	}
+	renderConsent(w, http.StatusOK, req, "")
+}
+
+// Approve handles the consent form. The user signs in on the form itself and
+// is sent back to the client with an authorization code, or with an
+// access_denied error if they declined.
+func (h *OAuthHandler) Approve(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		renderOAuthError(w, http.StatusBadRequest, "The request could not be read.")
+		return
+	}
+
+	req, ok := h.parseAuthorizeRequest(w, r, r.PostForm)
+	if !ok {
+		return
+	}
+	if r.PostForm.Get("action") != "allow" {
+		redirectWithParams(w, r, req.RedirectURI, url.Values{
+			"error": {"access_denied"},
+			"state": {req.State},
+		})
+		return
+	}
+
+	user, msg, status := h.authenticateUser(r, r.PostForm.Get("email"), r.PostForm.Get("password"), r.PostForm.Get("code"))
+	if msg != "" {
+		renderConsent(w, status, req, msg)
+		return
+	}
+
+	err = h.db.UpsertOAuthGrant(r.Context(), database.UpsertOAuthGrantParams{
+		UserID:   user.ID,
+		ClientID: req.Client.ID,
+		Scopes:   req.Scope,
+	})
+	if err != nil {
+		log.Printf("Error saving OAuth grant: %s", err)
+		renderOAuthError(w, http.StatusInternalServerError, "Something went wrong.")
+		return
+	}
+
+	code, err := auth.MakeOpaqueToken()
+	if err != nil {
+		log.Printf("Error creating authorization code: %s", err)
+		renderOAuthError(w, http.StatusInternalServerError, "Something went wrong.")
+		return
+	}
+	err = h.db.CreateOAuthAuthorizationCode(r.Context(), database.CreateOAuthAuthorizationCodeParams{
+		CodeHash:      auth.HashToken(code),
+		ExpiresAt:     time.Now().UTC().Add(oauthCodeTTL),
+		RedirectUri:   req.RedirectURI,
+		Scopes:        req.Scope,
+		CodeChallenge: req.CodeChallenge,
+		ClientID:      req.Client.ID,
+		UserID:        user.ID,
+	})
+	if err != nil {
+		log.Printf("Error saving authorization code: %s", err)
+		renderOAuthError(w, http.StatusInternalServerError, "Something went wrong.")
+		return
+	}
+
+	redirectWithParams(w, r, req.RedirectURI, url.Values{
+		"code":  {code},
+		"state": {req.State},
+	})
+}
+
+// Token is the token endpoint. It supports the authorization_code grant with
+// PKCE and the refresh_token grant. Refresh tokens are rotated on every use.
+func (h *OAuthHandler) Token(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	err := r.ParseForm()
+	if err != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_request", "The request could not be read")
+		return
+	}
+
+	client, ok := h.authenticateClient(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		h.exchangeCode(w, r, client)
+	case "refresh_token":
+		h.refresh(w, r, client)
+	default:
+		respondWithOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", "Only authorization_code and refresh_token are supported")
+	}
+}
+
+func (h *OAuthHandler) exchangeCode(w http.ResponseWriter, r *http.Request, client database.OauthClient) {
+	// Codes are only used up by the client and redirect URI they were
+	// issued for, so another client can't burn someone else's code.
+	code, err := h.db.UseOAuthAuthorizationCode(r.Context(), database.UseOAuthAuthorizationCodeParams{
+		CodeHash:    auth.HashToken(r.PostForm.Get("code")),
+		ClientID:    client.ID,
+		RedirectUri: r.PostForm.Get("redirect_uri"),
+	})
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Error using authorization code: %s", err)
+		}
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "Invalid or expired authorization code, or it was issued for another client or redirect URI")
+		return
+	}
+	if !auth.VerifyPKCE(r.PostForm.Get("code_verifier"), code.CodeChallenge) {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "Invalid code verifier")
+		return
+	}
+
+	h.issueTokens(w, r, client.ID, code.UserID, code.Scopes)
+}
+
+func (h *OAuthHandler) refresh(w http.ResponseWriter, r *http.Request, client database.OauthClient) {
+	// Only the client the token was issued to can rotate it, so presenting
+	// another client's token leaves it untouched.
+	tokenHash := auth.HashToken(r.PostForm.Get("refresh_token"))
+
+	// A client may ask for fewer scopes than it was granted, never more.
+	// This is checked before rotating, so a bad request doesn't use up the
+	// client's refresh token.
+	requested := r.PostForm.Get("scope")
+	if requested != "" {
+		current, err := h.db.GetOAuthRefreshToken(r.Context(), database.GetOAuthRefreshTokenParams{
+			TokenHash: tokenHash,
+			ClientID:  client.ID,
+		})
+		if err == nil && !grantsScopes(current.Scopes, requested) {
+			respondWithOAuthError(w, http.StatusBadRequest, "invalid_scope", "Scope exceeds what the user granted")
+			return
+		}
+	}
+
+	refreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		log.Printf("Error creating OAuth refresh token: %s", err)
+		respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "Something went wrong")
+		return
+	}
+
+	// Rotating the old token and saving its replacement happen together, so
+	// a failed save doesn't leave the client with a token that counts as
+	// reused when it retries.
+	var token database.OauthRefreshToken
+	scope := requested
+	err = h.db.InTx(r.Context(), func(q *database.Queries) error {
+		var err error
+		token, err = q.RotateOAuthRefreshToken(r.Context(), database.RotateOAuthRefreshTokenParams{
+			TokenHash: tokenHash,
+			ClientID:  client.ID,
+		})
+		if err != nil {
+			return err
+		}
+		if scope == "" {
+			scope = token.Scopes
+		}
+		return q.CreateOAuthRefreshToken(r.Context(), database.CreateOAuthRefreshTokenParams{
+			TokenHash: auth.HashToken(refreshToken),
+			ExpiresAt: time.Now().UTC().Add(oauthRefreshTokenTTL),
+			Scopes:    scope,
+			ClientID:  client.ID,
+			UserID:    token.UserID,
+		})
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			h.detectRefreshTokenReuse(r, tokenHash, client.ID)
+			respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "Invalid or expired refresh token")
+			return
+		}
+		log.Printf("Error rotating OAuth refresh token: %s", err)
+		respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "Something went wrong")
+		return
+	}
+
+	h.respondWithTokens(w, client.ID, token.UserID, scope, refreshToken)
+}
+
+// grantsScopes reports whether every scope in requested is one of granted.
+func grantsScopes(granted, requested string) bool {
+	for _, s := range auth.ParseScopes(requested) {
+		if !auth.HasScope(granted, s) {
+			return false
+		}
+	}
+	return true
+}
+
+// detectRefreshTokenReuse revokes every refresh token of a grant when one
+// that was already exchanged for a new one is presented again, like the
+// first-party refresh token families.
+func (h *OAuthHandler) detectRefreshTokenReuse(r *http.Request, tokenHash string, clientID uuid.UUID) {
+	token, err := h.db.GetOAuthRefreshToken(r.Context(), database.GetOAuthRefreshTokenParams{
+		TokenHash: tokenHash,
+		ClientID:  clientID,
+	})
+	if err != nil || !token.RotatedAt.Valid {
+		return
+	}
+
+	log.Printf("OAuth refresh token reuse detected for user %s (client %s), possible token theft; revoking grant's tokens", token.UserID, clientID)
+	recordSecurityEvent(r, h.db, token.UserID, securityEventRefreshTokenReused, "app "+clientID.String()+" refresh tokens revoked")
+	err = h.db.RevokeOAuthRefreshTokens(r.Context(), database.RevokeOAuthRefreshTokensParams{
+		UserID:   token.UserID,
+		ClientID: clientID,
+	})
+	if err != nil {
+		log.Printf("Error revoking OAuth refresh tokens: %s", err)
+	}
+}
+
+func (h *OAuthHandler) issueTokens(w http.ResponseWriter, r *http.Request, clientID, userID uuid.UUID, scope string) {
+	refreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		log.Printf("Error creating OAuth refresh token: %s", err)
+		respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "Something went wrong")
+		return
+	}
+	err = h.db.CreateOAuthRefreshToken(r.Context(), database.CreateOAuthRefreshTokenParams{
+		TokenHash: auth.HashToken(refreshToken),
+		ExpiresAt: time.Now().UTC().Add(oauthRefreshTokenTTL),
+		Scopes:    scope,
+		ClientID:  clientID,
+		UserID:    userID,
+	})
+	if err != nil {
+		log.Printf("Error saving OAuth refresh token: %s", err)
+		respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "Something went wrong")
+		return
+	}
+
+	h.respondWithTokens(w, clientID, userID, scope, refreshToken)
+}
+
+// respondWithTokens sends a new access token along with a refresh token that
+// has already been saved.
+func (h *OAuthHandler) respondWithTokens(w http.ResponseWriter, clientID, userID uuid.UUID, scope, refreshToken string) {
+	type response struct {
+		AccessToken  string `json:"access_token"`
+		TokenType    string `json:"token_type"`
+		ExpiresIn    int    `json:"expires_in"`
+		RefreshToken string `json:"refresh_token"`
+		Scope        string `json:"scope"`
+	}
+
+	accessToken, err := auth.MakeOAuthAccessToken(userID, clientID, scope, h.keys, oauthAccessTokenTTL)
+	if err != nil {
+		log.Printf("Error creating OAuth access token: %s", err)
+		respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "Something went wrong")
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, response{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(oauthAccessTokenTTL.Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        scope,
+	})
+}
+
+// parseAuthorizeRequest validates the parameters of an authorization request.
+// Problems with the client or redirect URI are shown to the user, since
+// redirecting to an unverified URI would be unsafe. Everything else is
+// reported back to the client through the redirect URI.
+func (h *OAuthHandler) parseAuthorizeRequest(w http.ResponseWriter, r *http.Request, values url.Values) (authorizeRequest, bool) {
+	clientID, err := uuid.Parse(values.Get("client_id"))
+	if err != nil {
+		renderOAuthError(w, http.StatusBadRequest, "Unknown client.")
+		return authorizeRequest{}, false
+	}
+	client, err := h.db.GetOAuthClient(r.Context(), clientID)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Error getting OAuth client: %s", err)
+		}
+		renderOAuthError(w, http.StatusBadRequest, "Unknown client.")
+		return authorizeRequest{}, false
+	}
+
+	redirectURI := values.Get("redirect_uri")
+	if !slices.Contains(strings.Fields(client.RedirectUris), redirectURI) {
+		renderOAuthError(w, http.StatusBadRequest, "The redirect URI is not registered for this client.")
+		return authorizeRequest{}, false
+	}
+
+	req := authorizeRequest{
+		Client:        client,
+		RedirectURI:   redirectURI,
+		Scope:         strings.Join(auth.ParseScopes(values.Get("scope")), " "),
+		State:         values.Get("state"),
+		CodeChallenge: values.Get("code_challenge"),
+	}
+
+	fail := func(errCode, description string) (authorizeRequest, bool) {
+		redirectWithParams(w, r, redirectURI, url.Values{
+			"error":             {errCode},
+			"error_description": {description},
+			"state":             {req.State},
+		})
+		return authorizeRequest{}, false
+	}
+	if values.Get("response_type") != "code" {
+		return fail("unsupported_response_type", "Only the code response type is supported")
+	}
+	if req.CodeChallenge == "" || values.Get("code_challenge_method") != "S256" {
+		return fail("invalid_request", "PKCE with the S256 method is required")
+	}
+	if req.Scope == "" {
+		return fail("invalid_scope", "At least one scope is required")
+	}
+	for _, scope := range auth.ParseScopes(req.Scope) {
+		if !slices.Contains(auth.Scopes, scope) {
+			return fail("invalid_scope", "Unknown scope: "+scope)
+		}
+	}
+	return req, true
+}
+
+// authenticateClient identifies the client from HTTP Basic auth or the
+// client_id and client_secret form fields. Public clients have no secret and
+// rely on PKCE instead.
+func (h *OAuthHandler) authenticateClient(w http.ResponseWriter, r *http.Request) (database.OauthClient, bool) {
+	clientIDString, secret, ok := r.BasicAuth()
+	if !ok {
+		clientIDString = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+
+	clientID, err := uuid.Parse(clientIDString)
+	if err != nil {
+		respondWithOAuthError(w, http.StatusUnauthorized, "invalid_client", "Unknown client")
+		return database.OauthClient{}, false
+	}
+	client, err := h.db.GetOAuthClient(r.Context(), clientID)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Error getting OAuth client: %s", err)
+		}
+		respondWithOAuthError(w, http.StatusUnauthorized, "invalid_client", "Unknown client")
+		return database.OauthClient{}, false
+	}
+
+	if client.SecretHash.Valid && subtle.ConstantTimeCompare([]byte(auth.HashToken(secret)), []byte(client.SecretHash.String)) != 1 {
+		respondWithOAuthError(w, http.StatusUnauthorized, "invalid_client", "Invalid client credentials")
+		return database.OauthClient{}, false
+	}
+	return client, true
+}
+
+// authenticateUser checks the credentials entered on the consent screen with
+// the same lockout rules as /api/login. Accounts with two-factor
+// authentication also need a current TOTP code. On failure it returns the
+// message to show and the status to show it with.
+func (h *OAuthHandler) authenticateUser(r *http.Request, email, password, code string) (database.User, string, int) {
+	const failedMsg = "Incorrect email, password or code"
+
+	attemptKeys := []string{lockout.AccountKey(email), lockout.IPKey(utils.ClientIP(r))}
+	wait, err := h.guard.Check(r.Context(), attemptKeys...)
+	if err != nil {
+		log.Printf("Error checking login attempts: %s", err)
+	}
+	if wait > 0 {
+		return database.User{}, "Too many failed attempts, try again later", http.StatusTooManyRequests
+	}
+
+	failed := func(userID uuid.UUID, reason string) (database.User, string, int) {
+		recordSecurityEvent(r, h.db, userID, securityEventLoginFailed, reason+" on OAuth consent")
+		_, err := h.guard.RecordFailure(r.Context(), attemptKeys...)
+		if err != nil {
+			log.Printf("Error recording failed login: %s", err)
+		}
+		return database.User{}, failedMsg, http.StatusUnauthorized
+	}
+
+	user, err := h.db.GetUserByEmail(r.Context(), email)
+	if err != nil {
+		auth.CheckPasswordHash(password, dummyPasswordHash())
+		return failed(uuid.Nil, "unknown email")
+	}
+	if auth.CheckPasswordHash(password, user.HashedPassword) != nil {
+		return failed(user.ID, "wrong password")
+	}
+	if user.DeactivatedAt.Valid {
+		return database.User{}, "This account is scheduled for deletion. Log in to Chirpy to restore it first.", http.StatusForbidden
+	}
+
+	totp, err := h.db.GetTOTPCredential(r.Context(), user.ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Error getting TOTP credential: %s", err)
+		return database.User{}, "Something went wrong", http.StatusInternalServerError
+	}
+	if err == nil && totp.ConfirmedAt.Valid {
+		step, ok := auth.ValidateTOTP(code, totp.Secret, time.Now())
+		if !ok {
+			return failed(user.ID, "wrong TOTP code")
+		}
+		_, err = h.db.UseTOTPStep(r.Context(), database.UseTOTPStepParams{
+			Step:   step,
+			UserID: user.ID,
+		})
+		if err != nil {
+			return failed(user.ID, "reused TOTP code")
+		}
+	}
+
+	err = h.guard.Reset(r.Context(), lockout.AccountKey(email))
+	if err != nil {
+		log.Printf("Error resetting login attempts: %s", err)
+	}
+	return user, "", http.StatusOK
+}
+
+func respondWithOAuthError(w http.ResponseWriter, code int, errCode, description string) {
+	utils.RespondWithJSON(w, code, map[string]string{
+		"error":             errCode,
+		"error_description": description,
+	})
+}
+
+// redirectWithParams sends the browser back to the client's redirect URI with
+// params added to its query.
+func redirectWithParams(w http.ResponseWriter, r *http.Request, redirectURI string, params url.Values) {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		renderOAuthError(w, http.StatusBadRequest, "The redirect URI is invalid.")
+		return
+	}
+	query := u.Query()
+	for key, values := range params {
+		if values[0] != "" {
+			query.Set(key, values[0])
+		}
+	}
+	u.RawQuery = query.Encode()
+	http.Redirect(w, r, u.String(), http.StatusSeeOther)
+}
+
+var consentTemplate = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html>
+
+<head>
+	<title>Authorize {{.Client}}</title>
+</head>
+
+<body>
+	<h1>Authorize {{.Client}}</h1>
+	<p>{{.Client}} wants to:</p>
+	<ul>
+		{{range .Scopes}}<li>{{.}}</li>
+		{{end}}
+	</ul>
+	{{if .Error}}<p><strong>{{.Error}}</strong></p>{{end}}
+	<form method="post" action="/oauth/authorize">
+		<input type="hidden" name="response_type" value="code">
+		<input type="hidden" name="client_id" value="{{.ClientID}}">
+		<input type="hidden" name="redirect_uri" value="{{.RedirectURI}}">
+		<input type="hidden" name="scope" value="{{.Scope}}">
+		<input type="hidden" name="state" value="{{.State}}">
+		<input type="hidden" name="code_challenge" value="{{.CodeChallenge}}">
+		<input type="hidden" name="code_challenge_method" value="S256">
+		<p><label>Email <input type="email" name="email" autocomplete="username"></label></p>
+		<p><label>Password <input type="password" name="password" autocomplete="current-password"></label></p>
+		<p><label>Two-factor code (if enabled) <input type="text" name="code" inputmode="numeric" autocomplete="one-time-code"></label></p>
+		<button type="submit" name="action" value="allow">Allow</button>
+		<button type="submit" name="action" value="deny">Deny</button>
+	</form>
+</body>
+
+</html>
+`))
+
+var oauthErrorTemplate = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html>
+
+<body>
+	<h1>Authorization failed</h1>
+	<p>{{.}}</p>
+</body>
+
+</html>
+`))
+
+func renderConsent(w http.ResponseWriter, code int, req authorizeRequest, errMsg string) {
+	scopes := []string{}
+	for _, scope := range auth.ParseScopes(req.Scope) {
+		scopes = append(scopes, scopeDescriptions[scope])
+	}
+
+	// The consent screen must not be framed, or another site could trick
+	// the user into clicking Allow.
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(code)
+	err := consentTemplate.Execute(w, map[string]any{
+		"Client":        req.Client.Name,
+		"ClientID":      req.Client.ID,
+		"RedirectURI":   req.RedirectURI,
+		"Scope":         req.Scope,
+		"Scopes":        scopes,
+		"State":         req.State,
+		"CodeChallenge": req.CodeChallenge,
+		"Error":         errMsg,
+	})
+	if err != nil {
+		log.Printf("Error rendering consent screen: %s", err)
+	}
+}
+
+func renderOAuthError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(code)
+	err := oauthErrorTemplate.Execute(w, msg)
+	if err != nil {
+		log.Printf("Error rendering OAuth error: %s", err)
+	}
+}
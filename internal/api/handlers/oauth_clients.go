@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yujen77300/Chirpy-Server/internal/auth"
+	"github.com/yujen77300/Chirpy-Server/internal/database"
+	"github.com/yujen77300/Chirpy-Server/internal/utils"
+)
+
+type oauthClientResponse struct {
+	ID           uuid.UUID `json:"client_id"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Confidential bool      `json:"confidential"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type oauthAuthorizationResponse struct {
+	ClientID   uuid.UUID `json:"client_id"`
+	ClientName string    `json:"client_name"`
+	Scopes     []string  `json:"scopes"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// RegisterClient registers a new OAuth client owned by the user. Confidential
+// clients get a secret, which is only returned here.
+func (h *OAuthHandler) RegisterClient(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Confidential bool     `json:"confidential"`
+	}
+	type response struct {
+		oauthClientResponse
+		ClientSecret string `json:"client_secret,omitempty"`
+	}
+
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID := principal.UserID
+
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	params.Name = strings.TrimSpace(params.Name)
+	if params.Name == "" || len(params.Name) > 100 {
+		utils.RespondWithError(w, http.StatusBadRequest, "Name must be between 1 and 100 characters")
+		return
+	}
+	if len(params.RedirectURIs) == 0 {
+		utils.RespondWithError(w, http.StatusBadRequest, "At least one redirect URI is required")
+		return
+	}
+	for _, redirectURI := range params.RedirectURIs {
+		if !isValidRedirectURI(redirectURI) {
+			utils.RespondWithError(w, http.StatusBadRequest, "Invalid redirect URI: "+redirectURI)
+			return
+		}
+	}
+
+	secret := ""
+	secretHash := sql.NullString{}
+	if params.Confidential {
+		secret, err = auth.MakeOpaqueToken()
+		if err != nil {
+			log.Printf("Error creating client secret: %s", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+			return
+		}
+		secretHash = sql.NullString{String: auth.HashToken(secret), Valid: true}
+	}
+
+	client, err := h.db.CreateOAuthClient(r.Context(), database.CreateOAuthClientParams{
+		Name:         params.Name,
+		SecretHash:   secretHash,
+		RedirectUris: strings.Join(params.RedirectURIs, " "),
+		UserID:       userID,
+	})
+	if err != nil {
+		log.Printf("Error creating OAuth client: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusCreated, response{
+		oauthClientResponse: newOAuthClientResponse(client),
+		ClientSecret:        secret,
+	})
+}
+
+// ListClients returns the OAuth clients the user has registered
+func (h *OAuthHandler) ListClients(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID := principal.UserID
+
+	clients, err := h.db.GetUserOAuthClients(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error getting OAuth clients: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	resp := []oauthClientResponse{}
+	for _, client := range clients {
+		resp = append(resp, newOAuthClientResponse(client))
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, resp)
+}
+
+// DeleteClient removes one of the user's OAuth clients, along with every
+// grant and token issued to it.
+func (h *OAuthHandler) DeleteClient(w http.ResponseWriter, r *http.Request) {
+	clientID, err := uuid.Parse(r.PathValue("clientID"))
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid client ID")
+		return
+	}
+
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID := principal.UserID
+
+	deleted, err := h.db.DeleteOAuthClient(r.Context(), database.DeleteOAuthClientParams{
+		ID:     clientID,
+		UserID: userID,
+	})
+	if err != nil {
+		log.Printf("Error deleting OAuth client: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+	if deleted == 0 {
+		utils.RespondWithError(w, http.StatusNotFound, "Client not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListAuthorizations returns the apps the user has authorized, most recently
+// authorized first.
+func (h *OAuthHandler) ListAuthorizations(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID := principal.UserID
+
+	grants, err := h.db.GetUserOAuthGrants(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error getting OAuth grants: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	resp := []oauthAuthorizationResponse{}
+	for _, grant := range grants {
+		resp = append(resp, oauthAuthorizationResponse{
+			ClientID:   grant.ClientID,
+			ClientName: grant.Name,
+			Scopes:     auth.ParseScopes(grant.Scopes),
+			CreatedAt:  grant.CreatedAt,
+			UpdatedAt:  grant.UpdatedAt,
+		})
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, resp)
+}
+
+// RevokeAuthorization withdraws the user's consent for an app. Its access
+// tokens stop working right away and its refresh tokens are revoked.
+func (h *OAuthHandler) RevokeAuthorization(w http.ResponseWriter, r *http.Request) {
+	clientID, err := uuid.Parse(r.PathValue("clientID"))
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid client ID")
+		return
+	}
+
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID := principal.UserID
+
+	deleted, err := h.db.DeleteOAuthGrant(r.Context(), database.DeleteOAuthGrantParams{
+		UserID:   userID,
+		ClientID: clientID,
+	})
+	if err != nil {
+		log.Printf("Error deleting OAuth grant: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+	if deleted == 0 {
+		utils.RespondWithError(w, http.StatusNotFound, "Authorization not found")
+		return
+	}
+
+	err = h.db.RevokeOAuthRefreshTokens(r.Context(), database.RevokeOAuthRefreshTokensParams{
+		UserID:   userID,
+		ClientID: clientID,
+	})
+	if err != nil {
+		log.Printf("Error revoking OAuth refresh tokens: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func newOAuthClientResponse(client database.OauthClient) oauthClientResponse {
+	return oauthClientResponse{
+		ID:           client.ID,
+		Name:         client.Name,
+		RedirectURIs: strings.Fields(client.RedirectUris),
+		Confidential: client.SecretHash.Valid,
+		CreatedAt:    client.CreatedAt,
+	}
+}
+
+// isValidRedirectURI accepts absolute URIs without a fragment. Plain http is
+// only allowed for loopback addresses, which native apps listen on; custom
+// schemes such as com.example.app:/callback are allowed for the same reason.
+func isValidRedirectURI(redirectURI string) bool {
+	if strings.ContainsAny(redirectURI, " \t\r\n") {
+		return false
+	}
+	u, err := url.Parse(redirectURI)
+	if err != nil || u.Scheme == "" || u.Fragment != "" {
+		return false
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "https":
+		return u.Host != ""
+	case "http":
+		host := u.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	case "javascript", "data", "file", "vbscript":
+		return false
+	default:
+		return true
+	}
+}
@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/yujen77300/Chirpy-Server/internal/auth"
+	"github.com/yujen77300/Chirpy-Server/internal/database"
+	"github.com/yujen77300/Chirpy-Server/internal/oidc"
+	"github.com/yujen77300/Chirpy-Server/internal/utils"
+)
+
+const (
+	// A login through the identity provider has to be finished within ten
+	// minutes.
+	oidcLoginTTL = time.Minute * 10
+	// oidcStateCookie ties the callback to the browser that started the
+	// login, so nobody can log a victim into the attacker's account.
+	oidcStateCookie = "chirpy_oidc_state"
+)
+
+var (
+	errUnverifiedEmail   = errors.New("email address not verified by the identity provider")
+	errUnverifiedAccount = errors.New("existing account's email address not verified")
+)
+
+// OIDCHandler logs users in through an external OpenID Connect provider.
+// External accounts are linked to Chirpy users by issuer and subject, or by
+// email address the first time if the provider has verified it.
+type OIDCHandler struct {
+	db       *database.Queries
+	provider *oidc.Provider
+	baseURL  string
+	auth     *AuthHandler
+}
+
+func NewOIDCHandler(db *database.Queries, provider *oidc.Provider, baseURL string, authHandler *AuthHandler) *OIDCHandler {
+	return &OIDCHandler{
+		db:       db,
+		provider: provider,
+		baseURL:  baseURL,
+		auth:     authHandler,
+	}
+}
+
+// Begin redirects the browser to the identity provider.
+func (h *OIDCHandler) Begin(w http.ResponseWriter, r *http.Request) {
+	if h.provider == nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Single sign-on is not configured")
+		return
+	}
+
+	state, err := auth.MakeOpaqueToken()
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+	nonce, err := oidc.NewNonce()
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+	verifier, err := oidc.NewPKCEVerifier()
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	authURL, err := h.provider.AuthCodeURL(r.Context(), state, nonce, verifier)
+	if err != nil {
+		log.Printf("Error starting OIDC login: %s", err)
+		utils.RespondWithError(w, http.StatusBadGateway, "Couldn't reach the identity provider")
+		return
+	}
+
+	err = h.db.CreateOIDCLoginState(r.Context(), database.CreateOIDCLoginStateParams{
+		StateHash:    auth.HashToken(state),
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    time.Now().UTC().Add(oidcLoginTTL),
+	})
+	if err != nil {
+		log.Printf("Error saving OIDC login state: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	h.setStateCookie(w, state, int(oidcLoginTTL.Seconds()))
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// Callback finishes the login when the identity provider redirects back, and
+// responds like a password login.
+func (h *OIDCHandler) Callback(w http.ResponseWriter, r *http.Request) {
+	if h.provider == nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Single sign-on is not configured")
+		return
+	}
+
+	query := r.URL.Query()
+	if query.Get("error") != "" {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Login was denied by the identity provider")
+		return
+	}
+
+	state := query.Get("state")
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		utils.RespondWithError(w, http.StatusBadRequest, "Login was started in another browser")
+		return
+	}
+	h.setStateCookie(w, "", -1)
+
+	loginState, err := h.db.ConsumeOIDCLoginState(r.Context(), auth.HashToken(state))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.RespondWithError(w, http.StatusBadRequest, "Invalid or expired login attempt")
+			return
+		}
+		log.Printf("Error getting OIDC login state: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	idToken, err := h.provider.Exchange(r.Context(), query.Get("code"), loginState.CodeVerifier, loginState.Nonce)
+	if err != nil {
+		log.Printf("Error finishing OIDC login: %s", err)
+		utils.RespondWithError(w, http.StatusUnauthorized, "Couldn't verify the identity provider's response")
+		return
+	}
+
+	user, err := h.linkUser(r.Context(), idToken)
+	if err != nil {
+		if errors.Is(err, errUnverifiedEmail) {
+			utils.RespondWithError(w, http.StatusForbidden, "Your identity provider hasn't verified your email address")
+			return
+		}
+		if errors.Is(err, errUnverifiedAccount) {
+			utils.RespondWithError(w, http.StatusConflict, "An account with this email address already exists. Log in with its password and verify the address before using single sign-on")
+			return
+		}
+		log.Printf("Error linking OIDC identity: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	h.auth.completeFirstFactor(w, r, user, "")
+}
+
+// linkUser returns the Chirpy user of an external identity. Identities seen
+// for the first time are linked to the user with the same email address, or
+// to a new user, but only if the provider has verified the address. An
+// existing user is only linked if Chirpy has verified the address too:
+// otherwise whoever registered it, maybe not its owner, would keep their
+// password alongside the owner's single sign-on.
+func (h *OIDCHandler) linkUser(ctx context.Context, idToken *oidc.IDToken) (database.User, error) {
+	identity, err := h.db.GetUserIdentity(ctx, database.GetUserIdentityParams{
+		Issuer:  idToken.Issuer,
+		Subject: idToken.Subject,
+	})
+	if err == nil {
+		err = h.db.TouchUserIdentity(ctx, database.TouchUserIdentityParams{
+			ID:    identity.ID,
+			Email: idToken.Email,
+		})
+		if err != nil {
+			log.Printf("Error updating OIDC identity: %s", err)
+		}
+		return h.db.GetUserByID(ctx, identity.UserID)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return database.User{}, err
+	}
+
+	if idToken.Email == "" || !idToken.EmailVerified {
+		return database.User{}, errUnverifiedEmail
+	}
+
+	user, err := h.db.GetUserByEmail(ctx, idToken.Email)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		user, err = h.createUser(ctx, idToken.Email)
+		if err != nil {
+			return database.User{}, err
+		}
+		// The provider has proven the new user owns the address.
+		user, err = h.db.VerifyUserEmail(ctx, database.VerifyUserEmailParams{
+			ID:    user.ID,
+			Email: user.Email,
+		})
+		if err != nil {
+			return database.User{}, err
+		}
+	case err != nil:
+		return database.User{}, err
+	case !user.EmailVerifiedAt.Valid:
+		return database.User{}, errUnverifiedAccount
+	}
+
+	_, err = h.db.CreateUserIdentity(ctx, database.CreateUserIdentityParams{
+		Issuer:  idToken.Issuer,
+		Subject: idToken.Subject,
+		Email:   idToken.Email,
+		UserID:  user.ID,
+	})
+	if err != nil {
+		return database.User{}, err
+	}
+	return user, nil
+}
+
+// createUser creates a user that can only log in through the identity
+// provider until they reset their password.
+func (h *OIDCHandler) createUser(ctx context.Context, email string) (database.User, error) {
+	password, err := auth.MakeOpaqueToken()
+	if err != nil {
+		return database.User{}, err
+	}
+	hashedPassword, err := auth.HashPassword(password)
+	if err != nil {
+		return database.User{}, fmt.Errorf("couldn't hash password: %w", err)
+	}
+	return h.db.CreateUser(ctx, database.CreateUserParams{
+		Email:          email,
+		HashedPassword: hashedPassword,
+	})
+}
+
+func (h *OIDCHandler) setStateCookie(w http.ResponseWriter, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    value,
+		Path:     "/api/login/oidc",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(h.baseURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yujen77300/Chirpy-Server/internal/utils"
+)
+
+// parseLimit reads the limit query parameter, falling back to defaultLimit
+// and capping it at maxLimit.
+func parseLimit(w http.ResponseWriter, r *http.Request, defaultLimit, maxLimit int) (int, bool) {
+	limit := defaultLimit
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			utils.RespondWithError(w, http.StatusBadRequest, "Invalid limit")
+			return 0, false
+		}
+		limit = min(n, maxLimit)
+	}
+	return limit, true
+}
+
+// pageCursor marks a position in a listing ordered by (created_at, id).
+type pageCursor struct {
+	CreatedAt time.Time `json:"t"`
+	ID        uuid.UUID `json:"id"`
+	// Before is set for cursors to the previous page, which ends just
+	// before the position instead of starting just after it.
+	Before bool `json:"b,omitempty"`
+}
+
+func decodePageCursor(s string) (pageCursor, error) {
+	c := pageCursor{}
+	err := decodeCursor(s, &c)
+	if err != nil || c.ID == uuid.Nil || c.CreatedAt.IsZero() {
+		return c, errInvalidCursor
+	}
+	return c, nil
+}
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// encodeCursor turns a position into the opaque string clients hand back
+// unchanged to get the page after it.
+func encodeCursor(v any) string {
+	b, _ := json.Marshal(v)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return errInvalidCursor
+	}
+	if json.Unmarshal(b, v) != nil {
+		return errInvalidCursor
+	}
+	return nil
+}
+
+// setPageLinks points the Link header at the next and previous pages. They
+// repeat the request's query with the encoded cursor; empty cursors are left
+// out.
+func setPageLinks(w http.ResponseWriter, r *http.Request, next, prev string) {
+	link := func(cursor, rel string) string {
+		query := r.URL.Query()
+		query.Set("cursor", cursor)
+		u := url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+		return "<" + u.String() + `>; rel="` + rel + `"`
+	}
+
+	links := []string{}
+	if next != "" {
+		links = append(links, link(next, "next"))
+	}
+	if prev != "" {
+		links = append(links, link(prev, "prev"))
+	}
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+}
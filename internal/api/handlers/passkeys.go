@@ -0,0 +1,440 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+	"github.com/yujen77300/Chirpy-Server/internal/database"
+	"github.com/yujen77300/Chirpy-Server/internal/lockout"
+	"github.com/yujen77300/Chirpy-Server/internal/utils"
+)
+
+// A registration or login ceremony has to be finished within five minutes.
+const passkeyCeremonyTTL = time.Minute * 5
+
+// PasskeyHandler registers WebAuthn passkeys and logs users in with them.
+// Passkeys require user verification, so a passkey login counts as both
+// factors and skips TOTP.
+type PasskeyHandler struct {
+	db       *database.Queries
+	webAuthn *webauthn.WebAuthn
+	limiter  *lockout.Guard
+	auth     *AuthHandler
+}
+
+func NewPasskeyHandler(db *database.Queries, webAuthn *webauthn.WebAuthn, limiter *lockout.Guard, authHandler *AuthHandler) *PasskeyHandler {
+	return &PasskeyHandler{
+		db:       db,
+		webAuthn: webAuthn,
+		limiter:  limiter,
+		auth:     authHandler,
+	}
+}
+
+type passkeyResponse struct {
+	ID         uuid.UUID  `json:"id"`
+	Name       string     `json:"name"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Synced     bool       `json:"synced"`
+}
+
+// ceremonyResponse starts a ceremony. Options are passed to
+// navigator.credentials.create() or .get(), and the session ID is sent back
+// with the result.
+type ceremonyResponse struct {
+	SessionID uuid.UUID `json:"session_id"`
+	Options   any       `json:"options"`
+}
+
+// passkeyUser adapts a user and their stored credentials to webauthn.User.
+type passkeyUser struct {
+	user        database.User
+	credentials []webauthn.Credential
+}
+
+func (u *passkeyUser) WebAuthnID() []byte {
+	return u.user.ID[:]
+}
+
+func (u *passkeyUser) WebAuthnName() string {
+	return u.user.Email
+}
+
+func (u *passkeyUser) WebAuthnDisplayName() string {
+	return u.user.Email
+}
+
+func (u *passkeyUser) WebAuthnIcon() string {
+	return ""
+}
+
+func (u *passkeyUser) WebAuthnCredentials() []webauthn.Credential {
+	return u.credentials
+}
+
+// BeginRegistration starts adding a passkey to the logged in user's account
+func (h *PasskeyHandler) BeginRegistration(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID := principal.UserID
+
+	user, err := h.loadUser(r, userID)
+	if err != nil {
+		log.Printf("Error loading user for passkey registration: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	exclusions := []protocol.CredentialDescriptor{}
+	for _, credential := range user.credentials {
+		exclusions = append(exclusions, credential.Descriptor())
+	}
+	creation, session, err := h.webAuthn.BeginRegistration(user, webauthn.WithExclusions(exclusions))
+	if err != nil {
+		log.Printf("Error beginning passkey registration: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	h.startCeremony(w, r, creation, session, uuid.NullUUID{UUID: userID, Valid: true})
+}
+
+// FinishRegistration verifies the new credential and stores it
+func (h *PasskeyHandler) FinishRegistration(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		SessionID  uuid.UUID       `json:"session_id"`
+		Name       string          `json:"name"`
+		Credential json.RawMessage `json:"credential"`
+	}
+
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID := principal.UserID
+
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+	params.Name = strings.TrimSpace(params.Name)
+	if params.Name == "" {
+		params.Name = "Passkey"
+	}
+	if len(params.Name) > 100 {
+		utils.RespondWithError(w, http.StatusBadRequest, "Name must be at most 100 characters")
+		return
+	}
+
+	session, ok := h.finishCeremony(w, r, params.SessionID)
+	if !ok {
+		return
+	}
+	if !session.userID.Valid || session.userID.UUID != userID {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid or expired session")
+		return
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBody(bytes.NewReader(params.Credential))
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid credential")
+		return
+	}
+
+	user, err := h.loadUser(r, userID)
+	if err != nil {
+		log.Printf("Error loading user for passkey registration: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+	credential, err := h.webAuthn.CreateCredential(user, session.data, parsed)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Couldn't verify credential")
+		return
+	}
+
+	transports := []string{}
+	for _, transport := range credential.Transport {
+		transports = append(transports, string(transport))
+	}
+	passkey, err := h.db.CreateWebAuthnCredential(r.Context(), database.CreateWebAuthnCredentialParams{
+		CredentialID:    credential.ID,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		Transports:      strings.Join(transports, " "),
+		Aaguid:          credential.Authenticator.AAGUID,
+		SignCount:       int64(credential.Authenticator.SignCount),
+		BackupEligible:  credential.Flags.BackupEligible,
+		BackupState:     credential.Flags.BackupState,
+		Name:            params.Name,
+		UserID:          userID,
+	})
+	if err != nil {
+		log.Printf("Error saving passkey: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusCreated, newPasskeyResponse(passkey))
+}
+
+// List returns the user's passkeys
+func (h *PasskeyHandler) List(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID := principal.UserID
+
+	passkeys, err := h.db.GetUserWebAuthnCredentials(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error getting passkeys: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	resp := []passkeyResponse{}
+	for _, passkey := range passkeys {
+		resp = append(resp, newPasskeyResponse(passkey))
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, resp)
+}
+
+// Delete removes one of the user's passkeys
+func (h *PasskeyHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	passkeyID, err := uuid.Parse(r.PathValue("passkeyID"))
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid passkey ID")
+		return
+	}
+
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID := principal.UserID
+
+	deleted, err := h.db.DeleteWebAuthnCredential(r.Context(), database.DeleteWebAuthnCredentialParams{
+		ID:     passkeyID,
+		UserID: userID,
+	})
+	if err != nil {
+		log.Printf("Error deleting passkey: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+	if deleted == 0 {
+		utils.RespondWithError(w, http.StatusNotFound, "Passkey not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// BeginLogin starts a passwordless login. No email is needed, the browser
+// offers the passkeys it has for this site. Anyone can start one, so they
+// are rate limited per client IP.
+func (h *PasskeyHandler) BeginLogin(w http.ResponseWriter, r *http.Request) {
+	limitKey := lockout.IPKey(utils.ClientIP(r))
+	wait, err := h.limiter.Check(r.Context(), limitKey)
+	if err != nil {
+		log.Printf("Error checking passkey login requests: %s", err)
+	}
+	if wait > 0 {
+		respondWithRetryAfter(w, http.StatusTooManyRequests, "Too many passkey logins started, try again later", wait)
+		return
+	}
+	_, err = h.limiter.RecordFailure(r.Context(), limitKey)
+	if err != nil {
+		log.Printf("Error recording passkey login request: %s", err)
+	}
+
+	assertion, session, err := h.webAuthn.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		log.Printf("Error beginning passkey login: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	h.startCeremony(w, r, assertion, session, uuid.NullUUID{})
+}
+
+// FinishLogin verifies the passkey assertion and issues the same tokens as a
+// password login.
+func (h *PasskeyHandler) FinishLogin(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		SessionID   uuid.UUID       `json:"session_id"`
+		Credential  json.RawMessage `json:"credential"`
+		DeviceLabel string          `json:"device_label"`
+	}
+
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	session, ok := h.finishCeremony(w, r, params.SessionID)
+	if !ok {
+		return
+	}
+	if session.userID.Valid {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid or expired session")
+		return
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(params.Credential))
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid credential")
+		return
+	}
+
+	var user *passkeyUser
+	credential, err := h.webAuthn.ValidateDiscoverableLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+		userID, err := uuid.FromBytes(userHandle)
+		if err != nil {
+			return nil, err
+		}
+		user, err = h.loadUser(r, userID)
+		return user, err
+	}, session.data, parsed)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Couldn't verify passkey")
+		return
+	}
+	if credential.Authenticator.CloneWarning {
+		log.Printf("Passkey sign counter went backwards for user %s, possible cloned authenticator", user.user.ID)
+		utils.RespondWithError(w, http.StatusUnauthorized, "Couldn't verify passkey")
+		return
+	}
+
+	err = h.db.UpdateWebAuthnCredentialUsage(r.Context(), database.UpdateWebAuthnCredentialUsageParams{
+		CredentialID: credential.ID,
+		SignCount:    int64(credential.Authenticator.SignCount),
+		BackupState:  credential.Flags.BackupState,
+	})
+	if err != nil {
+		log.Printf("Error updating passkey: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	h.auth.issueTokens(w, r, user.user, params.DeviceLabel)
+}
+
+type ceremonySession struct {
+	userID uuid.NullUUID
+	data   webauthn.SessionData
+}
+
+// startCeremony stores the ceremony's session data until it is finished and
+// responds with the options for the browser.
+func (h *PasskeyHandler) startCeremony(w http.ResponseWriter, r *http.Request, options any, session *webauthn.SessionData, userID uuid.NullUUID) {
+	data, err := json.Marshal(session)
+	if err != nil {
+		log.Printf("Error encoding passkey session: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	stored, err := h.db.CreateWebAuthnSession(r.Context(), database.CreateWebAuthnSessionParams{
+		Data:      string(data),
+		ExpiresAt: time.Now().UTC().Add(passkeyCeremonyTTL),
+		UserID:    userID,
+	})
+	if err != nil {
+		log.Printf("Error saving passkey session: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, ceremonyResponse{
+		SessionID: stored.ID,
+		Options:   options,
+	})
+}
+
+// finishCeremony takes a ceremony's session data out of storage. Each session
+// can only be finished once, so its challenge can't be replayed.
+func (h *PasskeyHandler) finishCeremony(w http.ResponseWriter, r *http.Request, sessionID uuid.UUID) (ceremonySession, bool) {
+	stored, err := h.db.ConsumeWebAuthnSession(r.Context(), sessionID)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Error getting passkey session: %s", err)
+		}
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid or expired session")
+		return ceremonySession{}, false
+	}
+
+	session := ceremonySession{userID: stored.UserID}
+	err = json.Unmarshal([]byte(stored.Data), &session.data)
+	if err != nil {
+		log.Printf("Error decoding passkey session: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return ceremonySession{}, false
+	}
+	return session, true
+}
+
+func (h *PasskeyHandler) loadUser(r *http.Request, userID uuid.UUID) (*passkeyUser, error) {
+	user, err := h.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		return nil, err
+	}
+	passkeys, err := h.db.GetUserWebAuthnCredentials(r.Context(), userID)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials := []webauthn.Credential{}
+	for _, passkey := range passkeys {
+		transports := []protocol.AuthenticatorTransport{}
+		for _, transport := range strings.Fields(passkey.Transports) {
+			transports = append(transports, protocol.AuthenticatorTransport(transport))
+		}
+		credentials = append(credentials, webauthn.Credential{
+			ID:              passkey.CredentialID,
+			PublicKey:       passkey.PublicKey,
+			AttestationType: passkey.AttestationType,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				BackupEligible: passkey.BackupEligible,
+				BackupState:    passkey.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    passkey.Aaguid,
+				SignCount: uint32(passkey.SignCount),
+			},
+		})
+	}
+	return &passkeyUser{user: user, credentials: credentials}, nil
+}
+
+func newPasskeyResponse(passkey database.WebauthnCredential) passkeyResponse {
+	resp := passkeyResponse{
+		ID:        passkey.ID,
+		Name:      passkey.Name,
+		CreatedAt: passkey.CreatedAt,
+		Synced:    passkey.BackupState,
+	}
+	if passkey.LastUsedAt.Valid {
+		resp.LastUsedAt = &passkey.LastUsedAt.Time
+	}
+	return resp
+}
@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/yujen77300/Chirpy-Server/internal/auth"
+	"github.com/yujen77300/Chirpy-Server/internal/database"
+	"github.com/yujen77300/Chirpy-Server/internal/lockout"
+	"github.com/yujen77300/Chirpy-Server/internal/mailer"
+	"github.com/yujen77300/Chirpy-Server/internal/revocation"
+	"github.com/yujen77300/Chirpy-Server/internal/utils"
+)
+
+// Password reset links are valid for one hour.
+const passwordResetTTL = time.Hour
+
+type PasswordHandler struct {
+	db          *database.Queries
+	mailer      mailer.Mailer
+	baseURL     string
+	revocations *revocation.List
+	limiter     *lockout.Guard
+}
+
+func NewPasswordHandler(db *database.Queries, mailer mailer.Mailer, baseURL string, revocations *revocation.List, limiter *lockout.Guard) *PasswordHandler {
+	return &PasswordHandler{
+		db:          db,
+		mailer:      mailer,
+		baseURL:     baseURL,
+		revocations: revocations,
+		limiter:     limiter,
+	}
+}
+
+// Forgot emails a password reset link. It answers the same way whether or not
+// the email belongs to an account, so it can't be used to find users.
+// Requests are rate limited per email address and client IP.
+func (h *PasswordHandler) Forgot(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+	}
+
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		log.Printf("Error decoding JSON: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	limitKeys := []string{lockout.AccountKey(params.Email), lockout.IPKey(utils.ClientIP(r))}
+	wait, err := h.limiter.Check(r.Context(), limitKeys...)
+	if err != nil {
+		log.Printf("Error checking password reset requests: %s", err)
+	}
+	if wait > 0 {
+		respondWithRetryAfter(w, http.StatusTooManyRequests, "Too many password resets requested, try again later", wait)
+		return
+	}
+	_, err = h.limiter.RecordFailure(r.Context(), limitKeys...)
+	if err != nil {
+		log.Printf("Error recording password reset request: %s", err)
+	}
+
+	user, err := h.db.GetUserByEmail(r.Context(), params.Email)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Error getting user for password reset: %s", err)
+		}
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	token, err := auth.MakeOpaqueToken()
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Couldn't create reset token")
+		return
+	}
+
+	err = h.db.CreatePasswordResetToken(r.Context(), database.CreatePasswordResetTokenParams{
+		TokenHash: auth.HashToken(token),
+		ExpiresAt: time.Now().UTC().Add(passwordResetTTL),
+		UserID:    user.ID,
+	})
+	if err != nil {
+		log.Printf("Error saving password reset token: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	msg := mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your Chirpy password",
+		Body: fmt.Sprintf("Someone asked to reset the password for your Chirpy account.\n\n"+
+			"Use this link within the next hour to choose a new one:\n%s/reset-password?token=%s\n\n"+
+			"If this wasn't you, you can ignore this email.\n",
+			h.baseURL, url.QueryEscape(token)),
+	}
+	// Send in the background so response times don't reveal which emails exist.
+	go func() {
+		if err := h.mailer.Send(context.Background(), msg); err != nil {
+			log.Printf("Error sending password reset email: %s", err)
+		}
+	}()
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Reset sets a new password using a token from a reset email and signs the
+// user out of every session. Access tokens stop working right away.
+func (h *PasswordHandler) Reset(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		log.Printf("Error decoding JSON: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	// Check the new password before using up the token, so the user can
+	// try again with a better one.
+	user, err := h.db.GetPasswordResetTokenUser(r.Context(), auth.HashToken(params.Token))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.RespondWithError(w, http.StatusBadRequest, "Invalid or expired reset token")
+			return
+		}
+		log.Printf("Error getting password reset token: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+	if !checkPasswordPolicy(w, params.Password, user.Email) {
+		return
+	}
+
+	resetToken, err := h.db.UsePasswordResetToken(r.Context(), auth.HashToken(params.Token))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.RespondWithError(w, http.StatusBadRequest, "Invalid or expired reset token")
+			return
+		}
+		log.Printf("Error using password reset token: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	hashedPassword, err := auth.HashPassword(params.Password)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Couldn't hash password")
+		return
+	}
+
+	_, err = h.db.UpdateUserPassword(r.Context(), database.UpdateUserPasswordParams{
+		ID:             resetToken.UserID,
+		HashedPassword: hashedPassword,
+	})
+	if err != nil {
+		log.Printf("Error updating password: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	err = h.db.InvalidatePasswordResetTokens(r.Context(), resetToken.UserID)
+	if err != nil {
+		log.Printf("Error invalidating password reset tokens: %s", err)
+	}
+
+	// Whoever knew the old password may have made other credentials with it.
+	err = revokeAllCredentials(r.Context(), h.db, h.revocations, resetToken.UserID)
+	if err != nil {
+		log.Printf("Error revoking credentials: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+	recordSecurityEvent(r, h.db, resetToken.UserID, securityEventPasswordReset, "")
+
+	w.WriteHeader(http.StatusNoContent)
+}
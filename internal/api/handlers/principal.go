@@ -0,0 +1,20 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/yujen77300/Chirpy-Server/internal/auth"
+	"github.com/yujen77300/Chirpy-Server/internal/utils"
+)
+
+// requirePrincipal returns the caller put in the context by the
+// authentication middleware. It only fails if the route was registered
+// without one.
+func requirePrincipal(w http.ResponseWriter, r *http.Request) (*auth.Principal, bool) {
+	principal, ok := auth.PrincipalFromContext(r.Context())
+	if !ok {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Missing or malformed token")
+		return nil, false
+	}
+	return principal, true
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/yujen77300/Chirpy-Server/internal/database"
+	"github.com/yujen77300/Chirpy-Server/internal/utils"
+)
+
+const maxSearchQueryLength = 256
+
+type chirpSearchResult struct {
+	chirpResponse
+	Rank float32 `json:"rank"`
+	// Snippet is an HTML fragment of the body with the matching words in
+	// <mark> tags. The rest of the body is escaped.
+	Snippet string `json:"snippet"`
+}
+
+// searchCursor marks a position in search results, which are ordered by
+// (rank, id) from the best match down.
+type searchCursor struct {
+	Rank float32   `json:"r"`
+	ID   uuid.UUID `json:"id"`
+}
+
+// Search finds chirps whose body matches q, best matches first. q takes
+// words, "quoted phrases", OR and -excluded words. Results can be limited
+// to author_id and are paged with limit and the next link in the Link
+// header.
+func (h *ChirpsHandler) Search(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	q := strings.TrimSpace(query.Get("q"))
+	if q == "" {
+		utils.RespondWithError(w, http.StatusBadRequest, "Missing search query")
+		return
+	}
+	if len(q) > maxSearchQueryLength {
+		utils.RespondWithError(w, http.StatusBadRequest, "Search query is too long")
+		return
+	}
+
+	limit, ok := parseLimit(w, r, 20, 100)
+	if !ok {
+		return
+	}
+
+	// One more row than needed tells whether another page follows.
+	params := database.SearchChirpsParams{
+		Query:    q,
+		RowLimit: int32(limit + 1),
+	}
+
+	if authorIDStr := query.Get("author_id"); authorIDStr != "" {
+		authorID, err := uuid.Parse(authorIDStr)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "Invalid author ID")
+			return
+		}
+		params.AuthorID = uuid.NullUUID{UUID: authorID, Valid: true}
+	}
+
+	if s := query.Get("cursor"); s != "" {
+		cursor := searchCursor{}
+		if decodeCursor(s, &cursor) != nil || cursor.ID == uuid.Nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "Invalid cursor")
+			return
+		}
+		params.CursorRank = sql.NullFloat64{Float64: float64(cursor.Rank), Valid: true}
+		params.CursorID = uuid.NullUUID{UUID: cursor.ID, Valid: true}
+	}
+
+	rows, err := h.db.SearchChirps(r.Context(), params)
+	if err != nil {
+		log.Printf("Error searching chirps: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	if len(rows) > limit {
+		rows = rows[:limit]
+		last := rows[len(rows)-1]
+		setPageLinks(w, r, encodeCursor(searchCursor{Rank: last.Rank, ID: last.ID}), "")
+	}
+
+	results := []chirpSearchResult{}
+	for _, row := range rows {
+		results = append(results, chirpSearchResult{
+			chirpResponse: chirpResponse{
+				ID:             row.ID,
+				CreatedAt:      row.CreatedAt,
+				UpdatedAt:      row.UpdatedAt,
+				Body:           row.Body,
+				UserID:         row.UserID,
+				Lang:           row.Lang.String,
+				Edited:         row.UpdatedAt.After(row.CreatedAt),
+				InReplyTo:      replyTo(row.InReplyTo),
+				ConversationID: conversationID(row.ID, row.RootID),
+			},
+			Rank:    row.Rank,
+			Snippet: row.Snippet,
+		})
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, results)
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yujen77300/Chirpy-Server/internal/database"
+	"github.com/yujen77300/Chirpy-Server/internal/utils"
+)
+
+// Security event types.
+const (
+	securityEventLoginSucceeded      = "login.succeeded"
+	securityEventLoginFailed         = "login.failed"
+	securityEventTokenRefreshed      = "token.refreshed"
+	securityEventRefreshTokenReused  = "token.reused"
+	securityEventSessionRevoked      = "session.revoked"
+	securityEventLoggedOut           = "logout"
+	securityEventTokensRevoked       = "tokens.revoked"
+	securityEventRoleChanged         = "role.changed"
+	securityEventPasswordChanged     = "password.changed"
+	securityEventPasswordReset       = "password.reset"
+	securityEventEmailChanged        = "email.changed"
+	securityEventEmailVerified       = "email.verified"
+	securityEventSubscriptionUpgrade = "subscription.upgraded"
+	securityEventAccountDeactivated  = "account.deactivated"
+	securityEventAccountReactivated  = "account.reactivated"
+)
+
+type securityEventResponse struct {
+	ID        uuid.UUID  `json:"id"`
+	Type      string     `json:"type"`
+	UserID    *uuid.UUID `json:"user_id"`
+	IPAddress string     `json:"ip_address"`
+	UserAgent string     `json:"user_agent"`
+	Details   string     `json:"details"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// recordSecurityEvent appends an event to the user's security log, with the
+// address and user agent of the request. userID is uuid.Nil when the event
+// isn't tied to a known account. Failing to record an event is logged but
+// doesn't fail the request.
+func recordSecurityEvent(r *http.Request, db *database.Queries, userID uuid.UUID, eventType, details string) {
+	err := db.CreateSecurityEvent(r.Context(), database.CreateSecurityEventParams{
+		UserID:    uuid.NullUUID{UUID: userID, Valid: userID != uuid.Nil},
+		EventType: eventType,
+		IpAddress: utils.ClientIP(r),
+		UserAgent: r.UserAgent(),
+		Details:   details,
+	})
+	if err != nil {
+		log.Printf("Error recording %s security event: %s", eventType, err)
+	}
+}
+
+// GetSecurityEvents returns the logged in user's own security events,
+// newest first. The limit query parameter defaults to 100 and is capped at
+// 1000.
+func (h *UserHandler) GetSecurityEvents(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	limit, ok := parseLimit(w, r, 100, 1000)
+	if !ok {
+		return
+	}
+
+	events, err := h.db.GetUserSecurityEvents(r.Context(), database.GetUserSecurityEventsParams{
+		UserID: uuid.NullUUID{UUID: principal.UserID, Valid: true},
+		Limit:  int32(limit),
+	})
+	if err != nil {
+		log.Printf("Error getting security events: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, newSecurityEventResponses(events))
+}
+
+// GetSecurityEvents returns security events across all users, newest first.
+// They can be filtered by the user_id and type query parameters, and by
+// since and until, RFC 3339 times bounding when they happened.
+func (h *AdminHandler) GetSecurityEvents(w http.ResponseWriter, r *http.Request) {
+	limit, ok := parseLimit(w, r, 100, 1000)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	params := database.ListSecurityEventsParams{
+		RowLimit: int32(limit),
+	}
+	if s := query.Get("user_id"); s != "" {
+		userID, err := uuid.Parse(s)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "Invalid user_id")
+			return
+		}
+		params.UserID = uuid.NullUUID{UUID: userID, Valid: true}
+	}
+	if s := query.Get("type"); s != "" {
+		params.EventType = sql.NullString{String: s, Valid: true}
+	}
+	for name, dst := range map[string]*sql.NullTime{"since": &params.Since, "until": &params.Until} {
+		s := query.Get(name)
+		if s == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "Invalid "+name+", expected an RFC 3339 time")
+			return
+		}
+		*dst = sql.NullTime{Time: t.UTC(), Valid: true}
+	}
+
+	events, err := h.db.ListSecurityEvents(r.Context(), params)
+	if err != nil {
+		log.Printf("Error listing security events: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, newSecurityEventResponses(events))
+}
+
+func newSecurityEventResponses(events []database.SecurityEvent) []securityEventResponse {
+	resp := []securityEventResponse{}
+	for _, event := range events {
+		item := securityEventResponse{
+			ID:        event.ID,
+			Type:      event.EventType,
+			IPAddress: event.IpAddress,
+			UserAgent: event.UserAgent,
+			Details:   event.Details,
+			CreatedAt: event.CreatedAt,
+		}
+		if event.UserID.Valid {
+			item.UserID = &event.UserID.UUID
+		}
+		resp = append(resp, item)
+	}
+	return resp
+}
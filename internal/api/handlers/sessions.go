@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yujen77300/Chirpy-Server/internal/database"
+	"github.com/yujen77300/Chirpy-Server/internal/revocation"
+	"github.com/yujen77300/Chirpy-Server/internal/utils"
+)
+
+type SessionHandler struct {
+	db          *database.Queries
+	revocations *revocation.List
+}
+
+func NewSessionHandler(db *database.Queries, revocations *revocation.List) *SessionHandler {
+	return &SessionHandler{
+		db:          db,
+		revocations: revocations,
+	}
+}
+
+// sessionResponse describes one logged in device. A session is a refresh
+// token family, so its ID stays the same across token rotations.
+type sessionResponse struct {
+	ID           uuid.UUID `json:"id"`
+	DeviceLabel  string    `json:"device_label"`
+	UserAgent    string    `json:"user_agent"`
+	IPAddress    string    `json:"ip_address"`
+	LastActiveAt time.Time `json:"last_active_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	Current      bool      `json:"current"`
+}
+
+// List returns the user's active sessions, most recently active first
+func (h *SessionHandler) List(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID := principal.UserID
+
+	tokens, err := h.db.GetUserSessions(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error getting sessions: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	sessions := []sessionResponse{}
+	for _, token := range tokens {
+		sessions = append(sessions, sessionResponse{
+			ID:           token.FamilyID,
+			DeviceLabel:  token.DeviceLabel,
+			UserAgent:    token.UserAgent,
+			IPAddress:    token.IpAddress,
+			LastActiveAt: token.CreatedAt,
+			ExpiresAt:    token.ExpiresAt,
+			Current:      token.FamilyID == principal.SessionID,
+		})
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, sessions)
+}
+
+// Revoke logs out a single session of the user
+func (h *SessionHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	sessionID, err := uuid.Parse(r.PathValue("sessionID"))
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid session ID")
+		return
+	}
+
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID := principal.UserID
+
+	revoked, err := h.db.RevokeUserSession(r.Context(), database.RevokeUserSessionParams{
+		FamilyID: sessionID,
+		UserID:   userID,
+	})
+	if err != nil {
+		log.Printf("Error revoking session: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+	if revoked == 0 {
+		utils.RespondWithError(w, http.StatusNotFound, "Session not found")
+		return
+	}
+	recordSecurityEvent(r, h.db, userID, securityEventSessionRevoked, "session "+sessionID.String())
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Logout ends the current session. Its refresh tokens are revoked and the
+// access token used for the request stops working immediately.
+func (h *SessionHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID := principal.UserID
+
+	if principal.SessionID != uuid.Nil {
+		_, err := h.db.RevokeUserSession(r.Context(), database.RevokeUserSessionParams{
+			FamilyID: principal.SessionID,
+			UserID:   userID,
+		})
+		if err != nil {
+			log.Printf("Error revoking session: %s", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+			return
+		}
+	}
+
+	err := h.revocations.RevokeToken(r.Context(), principal.TokenID, principal.ExpiresAt)
+	if err != nil {
+		log.Printf("Error revoking access token: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+	recordSecurityEvent(r, h.db, userID, securityEventLoggedOut, "session "+principal.SessionID.String())
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RevokeOthers logs out every session of the user except the current one
+func (h *SessionHandler) RevokeOthers(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID := principal.UserID
+
+	if principal.SessionID == uuid.Nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Current session is unknown, log in again")
+		return
+	}
+
+	err := h.db.RevokeOtherUserSessions(r.Context(), database.RevokeOtherUserSessionsParams{
+		UserID:   userID,
+		FamilyID: principal.SessionID,
+	})
+	if err != nil {
+		log.Printf("Error revoking sessions: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+	recordSecurityEvent(r, h.db, userID, securityEventSessionRevoked, "every session except "+principal.SessionID.String())
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// revokeAllCredentials signs a user out of everything: sessions, access
+// tokens, personal access tokens and the apps they authorized. Their
+// passkeys, TOTP and linked identities are kept, since those are ways to
+// log in again rather than credentials someone else could be holding.
+func revokeAllCredentials(ctx context.Context, db *database.Queries, revocations *revocation.List, userID uuid.UUID) error {
+	if err := db.RevokeUserRefreshTokens(ctx, userID); err != nil {
+		return fmt.Errorf("revoking refresh tokens: %w", err)
+	}
+	if err := db.RevokeUserPersonalAccessTokens(ctx, userID); err != nil {
+		return fmt.Errorf("revoking personal access tokens: %w", err)
+	}
+	if err := db.RevokeUserOAuthRefreshTokens(ctx, userID); err != nil {
+		return fmt.Errorf("revoking OAuth refresh tokens: %w", err)
+	}
+	// OAuth access tokens are checked against the grant on every request,
+	// so deleting the grants stops them at once.
+	if err := db.DeleteUserOAuthGrants(ctx, userID); err != nil {
+		return fmt.Errorf("deleting OAuth grants: %w", err)
+	}
+	if err := revocations.RevokeUserTokens(ctx, userID); err != nil {
+		return fmt.Errorf("revoking access tokens: %w", err)
+	}
+	return nil
+}
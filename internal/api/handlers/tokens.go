@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yujen77300/Chirpy-Server/internal/auth"
+	"github.com/yujen77300/Chirpy-Server/internal/database"
+	"github.com/yujen77300/Chirpy-Server/internal/utils"
+)
+
+// TokenHandler manages personal access tokens, long-lived credentials for
+// bots and scripts that only carry the scopes they were created with.
+type TokenHandler struct {
+	db *database.Queries
+}
+
+func NewTokenHandler(db *database.Queries) *TokenHandler {
+	return &TokenHandler{
+		db: db,
+	}
+}
+
+type personalAccessTokenResponse struct {
+	ID         uuid.UUID  `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// Create issues a new personal access token. The token itself is only
+// returned here; afterwards just its hash is kept.
+func (h *TokenHandler) Create(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expires_in_days"`
+	}
+	type response struct {
+		personalAccessTokenResponse
+		Token string `json:"token"`
+	}
+
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID := principal.UserID
+
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	params.Name = strings.TrimSpace(params.Name)
+	if params.Name == "" || len(params.Name) > 100 {
+		utils.RespondWithError(w, http.StatusBadRequest, "Name must be between 1 and 100 characters")
+		return
+	}
+	if len(params.Scopes) == 0 {
+		utils.RespondWithError(w, http.StatusBadRequest, "At least one scope is required")
+		return
+	}
+	for _, scope := range params.Scopes {
+		if !slices.Contains(auth.Scopes, scope) {
+			utils.RespondWithError(w, http.StatusBadRequest, "Unknown scope: "+scope)
+			return
+		}
+	}
+	slices.Sort(params.Scopes)
+	params.Scopes = slices.Compact(params.Scopes)
+	if params.ExpiresInDays < 0 {
+		utils.RespondWithError(w, http.StatusBadRequest, "expires_in_days can't be negative")
+		return
+	}
+
+	expiresAt := sql.NullTime{}
+	if params.ExpiresInDays > 0 {
+		expiresAt = sql.NullTime{Time: time.Now().UTC().AddDate(0, 0, params.ExpiresInDays), Valid: true}
+	}
+
+	token, err := auth.MakePersonalAccessToken()
+	if err != nil {
+		log.Printf("Error creating personal access token: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	pat, err := h.db.CreatePersonalAccessToken(r.Context(), database.CreatePersonalAccessTokenParams{
+		Name:      params.Name,
+		TokenHash: auth.HashToken(token),
+		Scopes:    strings.Join(params.Scopes, " "),
+		ExpiresAt: expiresAt,
+		UserID:    userID,
+	})
+	if err != nil {
+		log.Printf("Error saving personal access token: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusCreated, response{
+		personalAccessTokenResponse: newPersonalAccessTokenResponse(pat),
+		Token:                       token,
+	})
+}
+
+// List returns the user's active personal access tokens, newest first
+func (h *TokenHandler) List(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID := principal.UserID
+
+	pats, err := h.db.GetUserPersonalAccessTokens(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error getting personal access tokens: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	tokens := []personalAccessTokenResponse{}
+	for _, pat := range pats {
+		tokens = append(tokens, newPersonalAccessTokenResponse(pat))
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, tokens)
+}
+
+// Revoke permanently disables one of the user's personal access tokens
+func (h *TokenHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	tokenID, err := uuid.Parse(r.PathValue("tokenID"))
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid token ID")
+		return
+	}
+
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID := principal.UserID
+
+	revoked, err := h.db.RevokePersonalAccessToken(r.Context(), database.RevokePersonalAccessTokenParams{
+		ID:     tokenID,
+		UserID: userID,
+	})
+	if err != nil {
+		log.Printf("Error revoking personal access token: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+	if revoked == 0 {
+		utils.RespondWithError(w, http.StatusNotFound, "Token not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func newPersonalAccessTokenResponse(pat database.PersonalAccessToken) personalAccessTokenResponse {
+	resp := personalAccessTokenResponse{
+		ID:        pat.ID,
+		Name:      pat.Name,
+		Scopes:    auth.ParseScopes(pat.Scopes),
+		CreatedAt: pat.CreatedAt,
+	}
+	if pat.ExpiresAt.Valid {
+		resp.ExpiresAt = &pat.ExpiresAt.Time
+	}
+	if pat.LastUsedAt.Valid {
+		resp.LastUsedAt = &pat.LastUsedAt.Time
+	}
+	return resp
+}
@@ -1,29 +1,47 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/yujen77300/Chirpy-Server/internal/auth"
 	"github.com/yujen77300/Chirpy-Server/internal/database"
+	"github.com/yujen77300/Chirpy-Server/internal/lockout"
+	"github.com/yujen77300/Chirpy-Server/internal/mailer"
+	"github.com/yujen77300/Chirpy-Server/internal/models"
+	"github.com/yujen77300/Chirpy-Server/internal/revocation"
 	"github.com/yujen77300/Chirpy-Server/internal/utils"
-		"github.com/yujen77300/Chirpy-Server/internal/models"
 )
 
 type UserHandler struct {
-	db        *database.Queries
-	jwtSecret string
+	db          *database.Queries
+	keys        *auth.KeySet
+	mailer      mailer.Mailer
+	baseURL     string
+	revocations *revocation.List
+	// deletionGracePeriod is how long a deleted account can be restored by
+	// logging in before it is purged.
+	deletionGracePeriod time.Duration
+	// resendLimiter rate limits verification emails sent on request.
+	resendLimiter *lockout.Guard
 }
 
-func NewUserHandler(db *database.Queries, jwtSecret string) *UserHandler {
+func NewUserHandler(db *database.Queries, keys *auth.KeySet, mailer mailer.Mailer, baseURL string, revocations *revocation.List, deletionGracePeriod time.Duration, resendLimiter *lockout.Guard) *UserHandler {
 	return &UserHandler{
-		db:        db,
-		jwtSecret: jwtSecret,
+		db:                  db,
+		keys:                keys,
+		mailer:              mailer,
+		baseURL:             baseURL,
+		revocations:         revocations,
+		deletionGracePeriod: deletionGracePeriod,
+		resendLimiter:       resendLimiter,
 	}
 }
 
-
 func (h *UserHandler) Create(w http.ResponseWriter, r *http.Request) {
 	type input struct {
 		Password string `json:"password"`
@@ -42,6 +60,14 @@ func (h *UserHandler) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !isValidEmail(in.Email) {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid email address")
+		return
+	}
+	if !checkPasswordPolicy(w, in.Password, in.Email) {
+		return
+	}
+
 	hashedPassword, err := auth.HashPassword(in.Password)
 	if err != nil {
 		utils.RespondWithError(w, http.StatusInternalServerError, "Couldn't hash password")
@@ -58,13 +84,20 @@ func (h *UserHandler) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	err = sendVerificationEmail(h.mailer, h.keys, h.baseURL, user)
+	if err != nil {
+		log.Printf("Error creating verification email: %s", err)
+	}
+
 	utils.RespondWithJSON(w, http.StatusCreated, response{
 		User: models.User{
-			ID:          user.ID,
-			CreatedAt:   user.CreatedAt,
-			UpdatedAt:   user.UpdatedAt,
-			Email:       user.Email,
-			IsChirpyRed: user.IsChirpyRed,
+			ID:              user.ID,
+			CreatedAt:       user.CreatedAt,
+			UpdatedAt:       user.UpdatedAt,
+			Email:           user.Email,
+			IsChirpyRed:     user.IsChirpyRed,
+			IsEmailVerified: user.EmailVerifiedAt.Valid,
+			Role:            user.Role,
 		},
 	})
 
@@ -78,25 +111,39 @@ func (h *UserHandler) Update(w http.ResponseWriter, r *http.Request) {
 
 	type response struct {
 		models.User
+		// Token and RefreshToken start a new session after a password
+		// change, since every earlier one is signed out.
+		Token        string `json:"token,omitempty"`
+		RefreshToken string `json:"refresh_token,omitempty"`
 	}
 
-	tokenString, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		utils.RespondWithError(w, http.StatusUnauthorized, "Missing or malformed token")
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
 		return
 	}
+	userID := principal.UserID
 
-	userID, err := auth.ValidateJWT(tokenString, h.jwtSecret)
+	in := input{}
+	err := json.NewDecoder(r.Body).Decode(&in)
 	if err != nil {
-		utils.RespondWithError(w, http.StatusUnauthorized, "Invalid token")
+		log.Printf("Error decoding JSON: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
 		return
 	}
 
-	in := input{}
-	err = json.NewDecoder(r.Body).Decode(&in)
+	if !isValidEmail(in.Email) {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid email address")
+		return
+	}
+
+	current, err := h.db.GetUserByID(r.Context(), userID)
 	if err != nil {
-		log.Printf("Error decoding JSON: %s", err)
-		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		utils.RespondWithError(w, http.StatusUnauthorized, "Couldn't find user")
+		return
+	}
+	passwordChanged := auth.CheckPasswordHash(in.Password, current.HashedPassword) != nil
+	// Passwords set before the policy existed can be kept.
+	if passwordChanged && !checkPasswordPolicy(w, in.Password, in.Email) {
 		return
 	}
 
@@ -117,14 +164,160 @@ func (h *UserHandler) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// A new password signs the user out everywhere, including the session
+	// used for this request, which is replaced by a new one.
+	var accessToken, refreshToken string
+	if passwordChanged {
+		err = revokeAllCredentials(r.Context(), h.db, h.revocations, user.ID)
+		if err != nil {
+			log.Printf("Error revoking credentials: %s", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+			return
+		}
+		recordSecurityEvent(r, h.db, user.ID, securityEventPasswordChanged, "")
+
+		// The new access token must not fall under the revocation above.
+		issuedAt := auth.WithIssuedAt(h.revocations.ValidFrom(user.ID))
+		_, accessToken, refreshToken, err = startSession(r, h.db, h.keys, user, "", issuedAt)
+		if err != nil {
+			log.Printf("Error starting session: %s", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+			return
+		}
+	}
+	if user.Email != current.Email {
+		recordSecurityEvent(r, h.db, user.ID, securityEventEmailChanged, "from "+current.Email+" to "+user.Email)
+	}
+
+	// A new address has to be verified. Unchanged ones keep their state,
+	// and the user can ask for the email again if it got lost.
+	if user.Email != current.Email && !user.EmailVerifiedAt.Valid {
+		err = sendVerificationEmail(h.mailer, h.keys, h.baseURL, user)
+		if err != nil {
+			log.Printf("Error creating verification email: %s", err)
+		}
+	}
+
 	utils.RespondWithJSON(w, http.StatusOK, response{
 		User: models.User{
-			ID:          user.ID,
-			CreatedAt:   user.CreatedAt,
-			UpdatedAt:   user.UpdatedAt,
-			Email:       user.Email,
-			IsChirpyRed: user.IsChirpyRed,
+			ID:              user.ID,
+			CreatedAt:       user.CreatedAt,
+			UpdatedAt:       user.UpdatedAt,
+			Email:           user.Email,
+			IsChirpyRed:     user.IsChirpyRed,
+			IsEmailVerified: user.EmailVerifiedAt.Valid,
+			Role:            user.Role,
 		},
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+	})
+
+}
+
+// Verify marks the user's email address as verified using the token from a
+// verification email.
+func (h *UserHandler) Verify(w http.ResponseWriter, r *http.Request) {
+	type input struct {
+		Token string `json:"token"`
+	}
+
+	in := input{}
+	err := json.NewDecoder(r.Body).Decode(&in)
+	if err != nil {
+		log.Printf("Error decoding JSON: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	userID, email, err := auth.ValidateEmailVerificationToken(in.Token, h.keys)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid or expired verification token")
+		return
+	}
+
+	_, err = h.db.VerifyUserEmail(r.Context(), database.VerifyUserEmailParams{
+		ID:    userID,
+		Email: email,
 	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.RespondWithError(w, http.StatusBadRequest, "Invalid or expired verification token")
+			return
+		}
+		log.Printf("Error verifying email: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+	recordSecurityEvent(r, h.db, userID, securityEventEmailVerified, email)
 
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ResendVerification sends a new verification email to the logged in user.
+// Requests are rate limited per email address and client IP.
+func (h *UserHandler) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	userID := principal.UserID
+
+	user, err := h.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Couldn't find user")
+		return
+	}
+
+	if user.EmailVerifiedAt.Valid {
+		utils.RespondWithError(w, http.StatusConflict, "Email address is already verified")
+		return
+	}
+
+	limitKeys := []string{lockout.AccountKey(user.Email), lockout.IPKey(utils.ClientIP(r))}
+	wait, err := h.resendLimiter.Check(r.Context(), limitKeys...)
+	if err != nil {
+		log.Printf("Error checking verification email requests: %s", err)
+	}
+	if wait > 0 {
+		respondWithRetryAfter(w, http.StatusTooManyRequests, "Too many verification emails requested, try again later", wait)
+		return
+	}
+	_, err = h.resendLimiter.RecordFailure(r.Context(), limitKeys...)
+	if err != nil {
+		log.Printf("Error recording verification email request: %s", err)
+	}
+
+	err = sendVerificationEmail(h.mailer, h.keys, h.baseURL, user)
+	if err != nil {
+		log.Printf("Error creating verification email: %s", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// checkPasswordPolicy responds with every rule a new password breaks and
+// returns false if there are any.
+func checkPasswordPolicy(w http.ResponseWriter, password, email string) bool {
+	violations, err := auth.ValidatePassword(password, email)
+	if err != nil {
+		// A broken breached password list shouldn't stop everyone from
+		// setting a password.
+		log.Printf("Error checking breached passwords: %s", err)
+	}
+	if len(violations) == 0 {
+		return true
+	}
+
+	errs := make([]utils.FieldError, 0, len(violations))
+	for _, v := range violations {
+		errs = append(errs, utils.FieldError{
+			Field:   "password",
+			Code:    v.Code,
+			Message: v.Message,
+		})
+	}
+	utils.RespondWithValidationErrors(w, errs)
+	return false
 }
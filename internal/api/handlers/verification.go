@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/yujen77300/Chirpy-Server/internal/auth"
+	"github.com/yujen77300/Chirpy-Server/internal/database"
+	"github.com/yujen77300/Chirpy-Server/internal/mailer"
+)
+
+// Email verification links are valid for two days.
+const emailVerificationTTL = time.Hour * 48
+
+// VerificationPolicy lists what users who haven't verified their email
+// address yet are not allowed to do.
+type VerificationPolicy struct {
+	// BlockChirps stops unverified users from posting chirps.
+	BlockChirps bool
+}
+
+// ParseVerificationPolicy reads a comma separated list of restrictions, for
+// example "chirps".
+func ParseVerificationPolicy(s string) (VerificationPolicy, error) {
+	policy := VerificationPolicy{}
+	for _, name := range strings.Split(s, ",") {
+		switch strings.TrimSpace(name) {
+		case "":
+		case "chirps":
+			policy.BlockChirps = true
+		default:
+			return VerificationPolicy{}, fmt.Errorf("unknown restriction %q", name)
+		}
+	}
+	return policy, nil
+}
+
+func isValidEmail(email string) bool {
+	addr, err := mail.ParseAddress(email)
+	return err == nil && addr.Address == email
+}
+
+// sendVerificationEmail mails the user a signed link that verifies their
+// current email address. The mail is sent in the background.
+func sendVerificationEmail(m mailer.Mailer, keys *auth.KeySet, baseURL string, user database.User) error {
+	token, err := auth.MakeEmailVerificationToken(user.ID, user.Email, keys, emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	msg := mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your Chirpy email address",
+		Body: fmt.Sprintf("Welcome to Chirpy!\n\n"+
+			"Confirm this is your email address by opening this link within the next two days:\n%s/verify-email?token=%s\n",
+			baseURL, url.QueryEscape(token)),
+	}
+	go func() {
+		if err := m.Send(context.Background(), msg); err != nil {
+			log.Printf("Error sending verification email: %s", err)
+		}
+	}()
+	return nil
+}
@@ -64,6 +64,7 @@ func (h *WebhookHandler) HandlePolkaWebhooks(w http.ResponseWriter, r *http.Requ
 		utils.RespondWithError(w, http.StatusInternalServerError, "Couldn't update user")
 		return
 	}
+	recordSecurityEvent(r, h.db, params.Data.UserID, securityEventSubscriptionUpgrade, "Chirpy Red")
 
 	utils.RespondWithJSON(w, http.StatusNoContent, nil)
 
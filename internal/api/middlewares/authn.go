@@ -0,0 +1,239 @@
+package middlewares
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/yujen77300/Chirpy-Server/internal/auth"
+	"github.com/yujen77300/Chirpy-Server/internal/database"
+	"github.com/yujen77300/Chirpy-Server/internal/utils"
+)
+
+var (
+	errNoCredentials      = errors.New("no credentials")
+	errInvalidCredentials = errors.New("invalid credentials")
+)
+
+// AuthnMiddleware authenticates the credentials in the Authorization header
+// and stores the caller in the request context as an auth.Principal. Every
+// kind of credential the API accepts is recognised here, so handlers only
+// look at the principal.
+type AuthnMiddleware struct {
+	db   *database.Queries
+	keys *auth.KeySet
+}
+
+func NewAuthnMiddleware(db *database.Queries, keys *auth.KeySet) *AuthnMiddleware {
+	return &AuthnMiddleware{
+		db:   db,
+		keys: keys,
+	}
+}
+
+// OptionalScope authenticates the request if it carries credentials, which
+// must then have been granted scope. Requests without credentials, or with
+// ones that aren't valid, are handled anonymously.
+func (m *AuthnMiddleware) OptionalScope(scope string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, err := m.authenticate(r)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !principal.HasScope(scope) {
+			utils.RespondWithError(w, http.StatusForbidden, "Token is missing the "+scope+" scope")
+			return
+		}
+		m.serve(w, r, principal, next)
+	})
+}
+
+// RequireScope accepts any credential that was granted scope: an access
+// token, a personal access token or an OAuth access token. Impersonated
+// access tokens are only let through for reading.
+func (m *AuthnMiddleware) RequireScope(scope string, next http.Handler) http.Handler {
+	return m.require(func(w http.ResponseWriter, principal *auth.Principal) bool {
+		if !principal.HasScope(scope) {
+			utils.RespondWithError(w, http.StatusForbidden, "Token is missing the "+scope+" scope")
+			return false
+		}
+		if scope != auth.ScopeChirpsRead && principal.Impersonated() {
+			utils.RespondWithError(w, http.StatusForbidden, "Not allowed while impersonating a user")
+			return false
+		}
+		return true
+	}, next)
+}
+
+// RequireUser only accepts first-party access tokens, for endpoints about
+// the account that personal access tokens and third-party apps can't use.
+// Impersonated tokens are let through.
+func (m *AuthnMiddleware) RequireUser(next http.Handler) http.Handler {
+	return m.require(firstParty, next)
+}
+
+// RequireOwner only accepts first-party access tokens that aren't
+// impersonated, for endpoints that change the account and its credentials.
+// Admins acting as a user can look around but not change the account, and
+// a leaked personal access token can't be used to mint more credentials.
+func (m *AuthnMiddleware) RequireOwner(next http.Handler) http.Handler {
+	return m.require(func(w http.ResponseWriter, principal *auth.Principal) bool {
+		if !firstParty(w, principal) {
+			return false
+		}
+		if principal.Impersonated() {
+			utils.RespondWithError(w, http.StatusForbidden, "Not allowed while impersonating a user")
+			return false
+		}
+		return true
+	}, next)
+}
+
+func firstParty(w http.ResponseWriter, principal *auth.Principal) bool {
+	if !principal.FirstParty() {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Invalid token")
+		return false
+	}
+	return true
+}
+
+// require rejects requests without valid credentials, then lets allow decide
+// whether the principal may use the route. allow responds itself when it
+// returns false.
+func (m *AuthnMiddleware) require(allow func(http.ResponseWriter, *auth.Principal) bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, err := m.authenticate(r)
+		if errors.Is(err, errNoCredentials) {
+			utils.RespondWithError(w, http.StatusUnauthorized, "Missing or malformed token")
+			return
+		}
+		if err != nil {
+			utils.RespondWithError(w, http.StatusUnauthorized, "Invalid token")
+			return
+		}
+		if !allow(w, principal) {
+			return
+		}
+		m.serve(w, r, principal, next)
+	})
+}
+
+// serve hands the request on with the principal in its context. Requests
+// made with an impersonated token are written to the audit log first; if the
+// entry can't be written the request is refused, so nothing an admin does as
+// a user goes unrecorded.
+func (m *AuthnMiddleware) serve(w http.ResponseWriter, r *http.Request, principal *auth.Principal, next http.Handler) {
+	if principal.Impersonated() {
+		err := m.db.CreateAuditLogEntry(r.Context(), database.CreateAuditLogEntryParams{
+			Action:    "impersonation.request",
+			ActorID:   principal.ActorID,
+			SubjectID: principal.UserID,
+			Details:   r.Method + " " + r.URL.RequestURI(),
+		})
+		if err != nil {
+			log.Printf("Error writing audit log: %s", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Something went wrong")
+			return
+		}
+	}
+
+	next.ServeHTTP(w, r.WithContext(auth.ContextWithPrincipal(r.Context(), principal)))
+}
+
+// authenticate works out who sent the request from its bearer token.
+func (m *AuthnMiddleware) authenticate(r *http.Request) (*auth.Principal, error) {
+	tokenString, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		return nil, errNoCredentials
+	}
+
+	if auth.IsPersonalAccessToken(tokenString) {
+		return m.personalAccessToken(r.Context(), tokenString)
+	}
+	if claims, err := auth.ParseAccessToken(tokenString, m.keys); err == nil {
+		userID, err := claims.UserID()
+		if err != nil {
+			return nil, errInvalidCredentials
+		}
+		principal := &auth.Principal{
+			UserID:    userID,
+			Role:      claims.Role,
+			Scopes:    auth.Scopes,
+			TokenType: auth.TokenTypeAccess,
+			TokenID:   claims.ID,
+			SessionID: claims.Session(),
+			ActorID:   claims.ActorID(),
+		}
+		if claims.ExpiresAt != nil {
+			principal.ExpiresAt = claims.ExpiresAt.Time
+		}
+		return principal, nil
+	}
+	return m.oauthAccessToken(r.Context(), tokenString)
+}
+
+func (m *AuthnMiddleware) personalAccessToken(ctx context.Context, tokenString string) (*auth.Principal, error) {
+	pat, err := m.db.GetActivePersonalAccessToken(ctx, auth.HashToken(tokenString))
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Error getting personal access token: %s", err)
+		}
+		return nil, errInvalidCredentials
+	}
+
+	err = m.db.TouchPersonalAccessToken(ctx, pat.ID)
+	if err != nil {
+		log.Printf("Error updating personal access token: %s", err)
+	}
+	return &auth.Principal{
+		UserID:    pat.UserID,
+		Role:      auth.RoleUser,
+		Scopes:    auth.ParseScopes(pat.Scopes),
+		TokenType: auth.TokenTypePersonalAccess,
+	}, nil
+}
+
+// oauthAccessToken checks an OAuth access token. The user's grant is looked
+// up on every request, so revoking an app takes effect immediately rather
+// than when its access token expires.
+func (m *AuthnMiddleware) oauthAccessToken(ctx context.Context, tokenString string) (*auth.Principal, error) {
+	claims, err := auth.ParseOAuthAccessToken(tokenString, m.keys)
+	if err != nil {
+		return nil, errInvalidCredentials
+	}
+	userID, err := claims.UserID()
+	if err != nil {
+		return nil, errInvalidCredentials
+	}
+	clientID, err := claims.Client()
+	if err != nil {
+		return nil, errInvalidCredentials
+	}
+
+	_, err = m.db.GetOAuthGrant(ctx, database.GetOAuthGrantParams{
+		UserID:   userID,
+		ClientID: clientID,
+	})
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Error getting OAuth grant: %s", err)
+		}
+		return nil, errInvalidCredentials
+	}
+
+	principal := &auth.Principal{
+		UserID:    userID,
+		Role:      auth.RoleUser,
+		Scopes:    auth.ParseScopes(claims.Scope),
+		TokenType: auth.TokenTypeOAuthAccess,
+		TokenID:   claims.ID,
+		ClientID:  clientID,
+	}
+	if claims.ExpiresAt != nil {
+		principal.ExpiresAt = claims.ExpiresAt.Time
+	}
+	return principal, nil
+}
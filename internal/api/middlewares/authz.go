@@ -0,0 +1,35 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"github.com/yujen77300/Chirpy-Server/internal/auth"
+	"github.com/yujen77300/Chirpy-Server/internal/utils"
+)
+
+// AuthzMiddleware guards routes by the role of the authenticated principal.
+// It goes behind AuthnMiddleware, which puts the principal in the context.
+type AuthzMiddleware struct{}
+
+func NewAuthzMiddleware() *AuthzMiddleware {
+	return &AuthzMiddleware{}
+}
+
+// Require only lets a request through if the principal's role grants
+// permission. Only first-party access tokens carry a role, and impersonated
+// tokens are rejected whatever the user's role.
+func (m *AuthzMiddleware) Require(permission auth.Permission, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := auth.PrincipalFromContext(r.Context())
+		if !ok {
+			utils.RespondWithError(w, http.StatusUnauthorized, "Missing or malformed token")
+			return
+		}
+		if !principal.FirstParty() || principal.Impersonated() || !principal.Role.Can(permission) {
+			utils.RespondWithError(w, http.StatusForbidden, "Forbidden")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -3,18 +3,43 @@ package api
 import (
     "net/http"
     "sync/atomic"
+    "time"
 
+    "github.com/go-webauthn/webauthn/webauthn"
     "github.com/yujen77300/Chirpy-Server/internal/api/handlers"
     "github.com/yujen77300/Chirpy-Server/internal/api/middlewares"
+    "github.com/yujen77300/Chirpy-Server/internal/auth"
     "github.com/yujen77300/Chirpy-Server/internal/database"
+    "github.com/yujen77300/Chirpy-Server/internal/lockout"
+    "github.com/yujen77300/Chirpy-Server/internal/mailer"
+    "github.com/yujen77300/Chirpy-Server/internal/oidc"
+    "github.com/yujen77300/Chirpy-Server/internal/revocation"
 )
 
 type ServerConfig struct {
     DB             *database.Queries
-    Platform       string
-    JWTSecret      string
+    JWTKeys        *auth.KeySet
     PolkaKey       string
+    // Platform is "dev" on development machines, where /admin/reset is
+    // allowed.
+    Platform       string
     FileserverHits *atomic.Int32
+    Mailer         mailer.Mailer
+    // BaseURL is the public address used in links sent by mail.
+    BaseURL        string
+    Verification   handlers.VerificationPolicy
+    LoginGuard     *lockout.Guard
+    MagicLinkGuard *lockout.Guard
+    VerificationGuard *lockout.Guard
+    PasswordResetGuard *lockout.Guard
+    PasskeyLoginGuard *lockout.Guard
+    Revocations    *revocation.List
+    // DeletionGracePeriod is how long deleted accounts can be restored.
+    DeletionGracePeriod time.Duration
+    ChirpEdits     handlers.ChirpEditPolicy
+    WebAuthn       *webauthn.WebAuthn
+    // OIDCProvider is nil when single sign-on is not configured.
+    OIDCProvider   *oidc.Provider
 }
 
 type Server struct {
@@ -30,12 +55,36 @@ func NewServer(cfg ServerConfig) *Server {
 // Router sets up the HTTP routes
 func (s *Server) Router() http.Handler {
     healthHandler := handlers.NewHealthHandler()
-    authHandler := handlers.NewAuthHandler(s.config.DB, s.config.JWTSecret)
-    chirpsHandler := handlers.NewChirpsHandler(s.config.DB, s.config.JWTSecret)
-    usersHandler := handlers.NewUserHandler(s.config.DB, s.config.JWTSecret)
-    adminHandler := handlers.NewAdminHandler(s.config.DB, s.config.Platform, s.config.FileserverHits)
+    authHandler := handlers.NewAuthHandler(s.config.DB, s.config.JWTKeys, s.config.LoginGuard)
+    chirpsHandler := handlers.NewChirpsHandler(s.config.DB, s.config.Verification, s.config.ChirpEdits)
+    usersHandler := handlers.NewUserHandler(s.config.DB, s.config.JWTKeys, s.config.Mailer, s.config.BaseURL, s.config.Revocations, s.config.DeletionGracePeriod, s.config.VerificationGuard)
+    adminHandler := handlers.NewAdminHandler(s.config.DB, s.config.JWTKeys, s.config.Platform, s.config.FileserverHits, s.config.LoginGuard, s.config.Revocations)
     webhookHandler := handlers.NewWebhookHandler(s.config.DB, s.config.PolkaKey)
+    jwksHandler := handlers.NewJWKSHandler(s.config.JWTKeys)
+    mfaHandler := handlers.NewMFAHandler(s.config.DB)
+    passwordHandler := handlers.NewPasswordHandler(s.config.DB, s.config.Mailer, s.config.BaseURL, s.config.Revocations, s.config.PasswordResetGuard)
+    sessionHandler := handlers.NewSessionHandler(s.config.DB, s.config.Revocations)
+    tokenHandler := handlers.NewTokenHandler(s.config.DB)
+    oauthHandler := handlers.NewOAuthHandler(s.config.DB, s.config.JWTKeys, s.config.LoginGuard)
+    passkeyHandler := handlers.NewPasskeyHandler(s.config.DB, s.config.WebAuthn, s.config.PasskeyLoginGuard, authHandler)
+    oidcHandler := handlers.NewOIDCHandler(s.config.DB, s.config.OIDCProvider, s.config.BaseURL, authHandler)
+    magicLinkHandler := handlers.NewMagicLinkHandler(s.config.DB, s.config.Mailer, s.config.BaseURL, s.config.MagicLinkGuard, authHandler)
     metricsMiddleware := middlewares.NewMetricsMiddleware(s.config.FileserverHits)
+    authnMiddleware := middlewares.NewAuthnMiddleware(s.config.DB, s.config.JWTKeys)
+    authzMiddleware := middlewares.NewAuthzMiddleware()
+
+    // Every route that acts for a user declares the credentials it accepts:
+    // owner routes change the account, so they need a first-party access
+    // token that isn't impersonated.
+    user := func(h http.HandlerFunc) http.Handler {
+        return authnMiddleware.RequireUser(h)
+    }
+    owner := func(h http.HandlerFunc) http.Handler {
+        return authnMiddleware.RequireOwner(h)
+    }
+    admin := func(permission auth.Permission, h http.HandlerFunc) http.Handler {
+        return authnMiddleware.RequireUser(authzMiddleware.Require(permission, h))
+    }
 
     mux := http.NewServeMux()
 
@@ -43,17 +92,63 @@ func (s *Server) Router() http.Handler {
     mux.Handle("/app/assets/", metricsMiddleware.MetricsInc(http.StripPrefix("/app/assets", http.FileServer(http.Dir("./assets")))))
 
     mux.HandleFunc("GET /api/healthz", healthHandler.HealthCheck)
-    mux.HandleFunc("GET /admin/metrics", adminHandler.GetMetrics)
-    mux.HandleFunc("POST /admin/reset", adminHandler.Reset)
+    mux.HandleFunc("GET /.well-known/jwks.json", jwksHandler.GetJWKS)
+    mux.Handle("GET /admin/metrics", admin(auth.PermissionViewMetrics, adminHandler.GetMetrics))
+    mux.Handle("POST /admin/reset", admin(auth.PermissionResetDatabase, adminHandler.Reset))
+    mux.Handle("POST /admin/lockouts/clear", admin(auth.PermissionClearLockouts, adminHandler.ClearLockout))
+    mux.Handle("PUT /admin/users/{userID}/role", admin(auth.PermissionManageRoles, adminHandler.SetRole))
+    mux.Handle("POST /admin/users/{userID}/impersonate", admin(auth.PermissionImpersonate, adminHandler.Impersonate))
+    mux.Handle("GET /admin/audit-log", admin(auth.PermissionViewAuditLog, adminHandler.GetAuditLog))
+    mux.Handle("POST /admin/users/{userID}/revoke-tokens", admin(auth.PermissionRevokeTokens, adminHandler.RevokeTokens))
+    mux.Handle("GET /admin/security-events", admin(auth.PermissionViewSecurityEvents, adminHandler.GetSecurityEvents))
     mux.HandleFunc("POST /api/users", usersHandler.Create)
-    mux.HandleFunc("PUT /api/users", usersHandler.Update)
+    mux.Handle("PUT /api/users", owner(usersHandler.Update))
+    mux.Handle("DELETE /api/users/me", owner(usersHandler.Delete))
+    mux.HandleFunc("POST /api/users/verify", usersHandler.Verify)
+    mux.Handle("POST /api/users/verify/resend", user(usersHandler.ResendVerification))
+    mux.Handle("GET /api/me/security-events", user(usersHandler.GetSecurityEvents))
     mux.HandleFunc("POST /api/login", authHandler.Login)
+    mux.HandleFunc("POST /api/login/mfa", authHandler.LoginMFA)
+    mux.HandleFunc("POST /api/login/magic", magicLinkHandler.Request)
+    mux.HandleFunc("POST /api/login/magic/redeem", magicLinkHandler.Redeem)
+    mux.HandleFunc("GET /api/login/oidc", oidcHandler.Begin)
+    mux.HandleFunc("GET /api/login/oidc/callback", oidcHandler.Callback)
+    mux.HandleFunc("POST /api/login/passkey/begin", passkeyHandler.BeginLogin)
+    mux.HandleFunc("POST /api/login/passkey/finish", passkeyHandler.FinishLogin)
+    mux.Handle("POST /api/passkeys/register/begin", owner(passkeyHandler.BeginRegistration))
+    mux.Handle("POST /api/passkeys/register/finish", owner(passkeyHandler.FinishRegistration))
+    mux.Handle("GET /api/passkeys", owner(passkeyHandler.List))
+    mux.Handle("DELETE /api/passkeys/{passkeyID}", owner(passkeyHandler.Delete))
+    mux.Handle("POST /api/mfa/totp/enroll", owner(mfaHandler.EnrollTOTP))
+    mux.Handle("POST /api/mfa/totp/confirm", owner(mfaHandler.ConfirmTOTP))
+    mux.HandleFunc("POST /api/password/forgot", passwordHandler.Forgot)
+    mux.HandleFunc("POST /api/password/reset", passwordHandler.Reset)
     mux.HandleFunc("POST /api/refresh", authHandler.RefreshToken)
     mux.HandleFunc("POST /api/revoke", authHandler.RevokeToken)
-    mux.HandleFunc("POST /api/chirps", chirpsHandler.Create)
-    mux.HandleFunc("GET /api/chirps", chirpsHandler.GetAll)
-    mux.HandleFunc("GET /api/chirps/{chirpID}", chirpsHandler.GetByID)
-    mux.HandleFunc("DELETE /api/chirps/{chirpID}", chirpsHandler.Delete)
+    mux.Handle("POST /api/logout", user(sessionHandler.Logout))
+    mux.Handle("GET /api/sessions", user(sessionHandler.List))
+    mux.Handle("DELETE /api/sessions/{sessionID}", owner(sessionHandler.Revoke))
+    mux.Handle("POST /api/sessions/revoke-others", owner(sessionHandler.RevokeOthers))
+    mux.Handle("POST /api/tokens", owner(tokenHandler.Create))
+    mux.Handle("GET /api/tokens", owner(tokenHandler.List))
+    mux.Handle("DELETE /api/tokens/{tokenID}", owner(tokenHandler.Revoke))
+    mux.HandleFunc("GET /oauth/authorize", oauthHandler.Authorize)
+    mux.HandleFunc("POST /oauth/authorize", oauthHandler.Approve)
+    mux.HandleFunc("POST /oauth/token", oauthHandler.Token)
+    mux.Handle("POST /api/oauth/clients", owner(oauthHandler.RegisterClient))
+    mux.Handle("GET /api/oauth/clients", owner(oauthHandler.ListClients))
+    mux.Handle("DELETE /api/oauth/clients/{clientID}", owner(oauthHandler.DeleteClient))
+    mux.Handle("GET /api/oauth/authorizations", owner(oauthHandler.ListAuthorizations))
+    mux.Handle("DELETE /api/oauth/authorizations/{clientID}", owner(oauthHandler.RevokeAuthorization))
+    mux.Handle("POST /api/chirps", authnMiddleware.RequireScope(auth.ScopeChirpsWrite, http.HandlerFunc(chirpsHandler.Create)))
+    mux.Handle("GET /api/chirps", authnMiddleware.OptionalScope(auth.ScopeChirpsRead, http.HandlerFunc(chirpsHandler.GetAll)))
+    mux.Handle("GET /api/chirps/search", authnMiddleware.OptionalScope(auth.ScopeChirpsRead, http.HandlerFunc(chirpsHandler.Search)))
+    mux.Handle("GET /api/chirps/{chirpID}", authnMiddleware.OptionalScope(auth.ScopeChirpsRead, http.HandlerFunc(chirpsHandler.GetByID)))
+    mux.Handle("GET /api/chirps/{chirpID}/history", authnMiddleware.OptionalScope(auth.ScopeChirpsRead, http.HandlerFunc(chirpsHandler.History)))
+    mux.Handle("GET /api/chirps/{chirpID}/replies", authnMiddleware.OptionalScope(auth.ScopeChirpsRead, http.HandlerFunc(chirpsHandler.Replies)))
+    mux.Handle("GET /api/chirps/{chirpID}/thread", authnMiddleware.OptionalScope(auth.ScopeChirpsRead, http.HandlerFunc(chirpsHandler.Thread)))
+    mux.Handle("PUT /api/chirps/{chirpID}", authnMiddleware.RequireScope(auth.ScopeChirpsWrite, http.HandlerFunc(chirpsHandler.Update)))
+    mux.Handle("DELETE /api/chirps/{chirpID}", authnMiddleware.RequireScope(auth.ScopeChirpsWrite, http.HandlerFunc(chirpsHandler.Delete)))
     mux.HandleFunc("POST /api/polka/webhooks", webhookHandler.HandlePolkaWebhooks)
 
     return mux
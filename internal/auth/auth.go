@@ -2,6 +2,7 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -11,38 +12,238 @@ import (
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type TokenType string
 
 const (
 	TokenTypeAccess TokenType = "chirpy-access"
+	// TokenTypeMFAPending is issued after a correct password for accounts
+	// with two-factor authentication, and only unlocks the second login step.
+	TokenTypeMFAPending TokenType = "chirpy-mfa-pending"
+	// TokenTypeEmailVerification is sent by mail to prove the user owns
+	// the address in its email claim.
+	TokenTypeEmailVerification TokenType = "chirpy-email-verification"
+	// TokenTypeOAuthAccess is issued to third-party OAuth clients and only
+	// grants the scopes the user consented to.
+	TokenTypeOAuthAccess TokenType = "chirpy-oauth-access"
+	// TokenTypePersonalAccess names personal access tokens, which are opaque
+	// rather than JWTs.
+	TokenTypePersonalAccess TokenType = "chirpy-personal-access"
 )
 
+type emailVerificationClaims struct {
+	jwt.RegisteredClaims
+	Email string `json:"email"`
+}
+
 func HashPassword(password string) (string, error) {
-	hashedBytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return DefaultPasswordHasher.Hash(password)
+}
+
+func CheckPasswordHash(password, hash string) error {
+	return DefaultPasswordHasher.Verify(password, hash)
+}
+
+// PasswordNeedsRehash reports whether a stored hash should be replaced the
+// next time the plain password is known, for example after a login.
+func PasswordNeedsRehash(hash string) bool {
+	return DefaultPasswordHasher.NeedsRehash(hash)
+}
+
+// AccessClaims are the claims carried by an access token.
+type AccessClaims struct {
+	jwt.RegisteredClaims
+	// SessionID is the refresh token family the access token was issued for.
+	SessionID string `json:"sid,omitempty"`
+	// Role is the user's role when the token was issued.
+	Role Role `json:"role,omitempty"`
+	// Actor is set when an admin acts as the user (RFC 8693 act claim).
+	Actor *Actor `json:"act,omitempty"`
+}
+
+// Actor names who is really behind an impersonated access token.
+type Actor struct {
+	Subject string `json:"sub"`
+}
+
+// ClaimOption adds optional claims to an access token.
+type ClaimOption func(*AccessClaims)
+
+// WithSessionID ties an access token to the session (refresh token family)
+// it was issued for.
+func WithSessionID(sessionID uuid.UUID) ClaimOption {
+	return func(c *AccessClaims) {
+		c.SessionID = sessionID.String()
+	}
+}
+
+// WithRole records the user's role in an access token.
+func WithRole(role Role) ClaimOption {
+	return func(c *AccessClaims) {
+		c.Role = role
+	}
+}
+
+// WithActor marks an access token as issued to an admin acting as the user.
+func WithActor(actorID uuid.UUID) ClaimOption {
+	return func(c *AccessClaims) {
+		c.Actor = &Actor{Subject: actorID.String()}
+	}
+}
+
+// WithIssuedAt sets the token's issue time, for tokens issued right after
+// the user's earlier tokens were revoked. Revocations are kept to the second,
+// so a token issued in the same second would be revoked along with them.
+func WithIssuedAt(t time.Time) ClaimOption {
+	return func(c *AccessClaims) {
+		c.IssuedAt = jwt.NewNumericDate(t)
+	}
+}
+
+// UserID returns the user the token was issued to.
+func (c *AccessClaims) UserID() (uuid.UUID, error) {
+	id, err := uuid.Parse(c.Subject)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("invalid user ID: %w", err)
+	}
+	return id, nil
+}
+
+// ActorID returns the admin acting as the user, or uuid.Nil for tokens that
+// aren't impersonated.
+func (c *AccessClaims) ActorID() uuid.UUID {
+	if c.Actor == nil {
+		return uuid.Nil
+	}
+	id, err := uuid.Parse(c.Actor.Subject)
+	if err != nil {
+		return uuid.Nil
+	}
+	return id
+}
+
+// Session returns the session the token belongs to, or uuid.Nil for tokens
+// that were issued without one.
+func (c *AccessClaims) Session() uuid.UUID {
+	id, err := uuid.Parse(c.SessionID)
+	if err != nil {
+		return uuid.Nil
+	}
+	return id
+}
+
+func MakeJWT(userID uuid.UUID, keys *KeySet, expiresIn time.Duration, opts ...ClaimOption) (string, error) {
+	claims := AccessClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    string(TokenTypeAccess),
+			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
+			Subject:   userID.String(),
+			ID:        uuid.NewString(),
+		},
+	}
+	for _, opt := range opts {
+		opt(&claims)
+	}
+
+	tokenString, err := keys.sign(claims)
 	if err != nil {
 		return "", err
 	}
-	return string(hashedBytes), nil
+
+	return tokenString, nil
 }
 
-func CheckPasswordHash(password, hash string) error {
-	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+// ParseAccessToken validates an access token and returns all of its claims.
+// Revoked tokens are rejected with ErrTokenRevoked.
+func ParseAccessToken(tokenString string, keys *KeySet) (*AccessClaims, error) {
+	claims := AccessClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, &claims, keys.keyfunc)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Issuer != string(TokenTypeAccess) {
+		return nil, errors.New("invalid issuer")
+	}
+	if claims.Actor != nil && claims.ActorID() == uuid.Nil {
+		return nil, errors.New("invalid actor")
+	}
+	err = keys.checkRevoked(claims.RegisteredClaims)
+	if err != nil {
+		return nil, err
+	}
+	return &claims, nil
+}
+
+// ValidateJWT returns the user an access token was issued to and, for
+// impersonated tokens, the admin acting as them. actorID is uuid.Nil
+// otherwise.
+func ValidateJWT(tokenString string, keys *KeySet) (userID, actorID uuid.UUID, err error) {
+	claims, err := ParseAccessToken(tokenString, keys)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, err
+	}
+	userID, err = claims.UserID()
+	if err != nil {
+		return uuid.Nil, uuid.Nil, err
+	}
+	return userID, claims.ActorID(), nil
 }
 
-func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
+// MakeMFAToken creates the short-lived token that carries a user from the
+// password step of a login to the second factor.
+func MakeMFAToken(userID uuid.UUID, keys *KeySet, expiresIn time.Duration) (string, error) {
+	return makeToken(userID, TokenTypeMFAPending, keys, expiresIn)
+}
+
+func ValidateMFAToken(tokenString string, keys *KeySet) (uuid.UUID, error) {
+	return validateToken(tokenString, TokenTypeMFAPending, keys)
+}
+
+// MakeEmailVerificationToken signs a token for a verification link. It is
+// bound to the email so it stops working if the user changes address.
+func MakeEmailVerificationToken(userID uuid.UUID, email string, keys *KeySet, expiresIn time.Duration) (string, error) {
+	claims := emailVerificationClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    string(TokenTypeEmailVerification),
+			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
+			Subject:   userID.String(),
+		},
+		Email: email,
+	}
+	return keys.sign(claims)
+}
+
+// ValidateEmailVerificationToken returns the user ID and email a verification
+// token was issued for.
+func ValidateEmailVerificationToken(tokenString string, keys *KeySet) (uuid.UUID, string, error) {
+	claims := emailVerificationClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, &claims, keys.keyfunc)
+	if err != nil {
+		return uuid.Nil, "", err
+	}
+	if claims.Issuer != string(TokenTypeEmailVerification) {
+		return uuid.Nil, "", errors.New("invalid issuer")
+	}
+
+	id, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return uuid.Nil, "", fmt.Errorf("invalid user ID: %w", err)
+	}
+	return id, claims.Email, nil
+}
+
+func makeToken(userID uuid.UUID, tokenType TokenType, keys *KeySet, expiresIn time.Duration) (string, error) {
 	claims := jwt.RegisteredClaims{
-		Issuer:    "chirpy-access",
+		Issuer:    string(tokenType),
 		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
 		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
 		Subject:   userID.String(),
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-	tokenString, err := token.SignedString([]byte(tokenSecret))
+	tokenString, err := keys.sign(claims)
 	if err != nil {
 		return "", err
 	}
@@ -50,12 +251,12 @@ func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (str
 	return tokenString, nil
 }
 
-func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
+func validateToken(tokenString string, tokenType TokenType, keys *KeySet) (uuid.UUID, error) {
 	claimsStruct := jwt.RegisteredClaims{}
 	token, err := jwt.ParseWithClaims(
 		tokenString,
 		&claimsStruct,
-		func(token *jwt.Token) (any, error) { return []byte(tokenSecret), nil },
+		keys.keyfunc,
 	)
 	if err != nil {
 		return uuid.Nil, err
@@ -70,7 +271,7 @@ func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
 	if err != nil {
 		return uuid.Nil, err
 	}
-	if issuer != string(TokenTypeAccess) {
+	if issuer != string(tokenType) {
 		return uuid.Nil, errors.New("invalid issuer")
 	}
 
@@ -109,6 +310,12 @@ func GetAPIKey(headers http.Header) (string, error) {
 }
 
 func MakeRefreshToken() (string, error) {
+	return MakeOpaqueToken()
+}
+
+// MakeOpaqueToken returns a random hex encoded token, used for refresh tokens
+// and for single-use links sent by mail.
+func MakeOpaqueToken() (string, error) {
 	randomBytes := make([]byte, 32)
 	_, err := rand.Read(randomBytes)
 	if err != nil {
@@ -117,3 +324,10 @@ func MakeRefreshToken() (string, error) {
 
 	return hex.EncodeToString(randomBytes), nil
 }
+
+// HashToken hashes a high-entropy secret such as a recovery code for storage.
+// Unlike passwords these don't need a slow hash.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
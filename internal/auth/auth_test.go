@@ -1,11 +1,21 @@
 package auth
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/hex"
 	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func TestCheckPasswordHash(t *testing.T) {
@@ -63,15 +73,242 @@ func TestCheckPasswordHash(t *testing.T) {
 	}
 }
 
+func TestPasswordNeedsRehash(t *testing.T) {
+	password := "correctPassword123!"
+	bcryptHash, _ := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	currentHash, _ := HashPassword(password)
+
+	weaker := DefaultArgon2Params
+	weaker.Iterations = 1
+	weakerHash, _ := NewPasswordHasher(weaker).Hash(password)
+
+	tests := []struct {
+		name       string
+		hash       string
+		wantRehash bool
+	}{
+		{
+			name:       "Legacy bcrypt hash",
+			hash:       string(bcryptHash),
+			wantRehash: true,
+		},
+		{
+			name:       "Current argon2id hash",
+			hash:       currentHash,
+			wantRehash: false,
+		},
+		{
+			name:       "Argon2id hash with other parameters",
+			hash:       weakerHash,
+			wantRehash: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := CheckPasswordHash(password, tt.hash); err != nil {
+				t.Errorf("CheckPasswordHash() error = %v", err)
+			}
+			if got := PasswordNeedsRehash(tt.hash); got != tt.wantRehash {
+				t.Errorf("PasswordNeedsRehash() = %v, want %v", got, tt.wantRehash)
+			}
+		})
+	}
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	// Build a small breached password file in the HIBP format.
+	breachedPasswords := []string{"password1", "letmein123", "correct horse", "qwertyuiop", "sunshine99"}
+	var lines []string
+	for i, password := range breachedPasswords {
+		sum := sha1.Sum([]byte(password))
+		lines = append(lines, strings.ToUpper(hex.EncodeToString(sum[:]))+":"+strings.Repeat("7", i+1))
+	}
+	slices.Sort(lines)
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\r\n")+"\r\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	breached, err := OpenBreachedPasswordFile(path)
+	if err != nil {
+		t.Fatalf("OpenBreachedPasswordFile() error = %v", err)
+	}
+	defer breached.Close()
+
+	policy := PasswordPolicy{
+		MinLength: 8,
+		MaxLength: 72,
+		Breached:  breached,
+	}
+
+	tests := []struct {
+		name      string
+		password  string
+		wantCodes []string
+	}{
+		{name: "Acceptable password", password: "tr0ub4dor&3x"},
+		{name: "Empty password", password: "", wantCodes: []string{PasswordTooShort}},
+		{name: "Short password", password: "abc123", wantCodes: []string{PasswordTooShort}},
+		{name: "Length counts characters", password: "pässwörd"},
+		{name: "Too long", password: strings.Repeat("a", 73), wantCodes: []string{PasswordTooLong}},
+		{name: "Matches the email", password: "Walter.White@example.com", wantCodes: []string{PasswordMatchesEmail}},
+		{name: "Matches the email's local part", password: "walter.white", wantCodes: []string{PasswordMatchesEmail}},
+		{name: "Breached password", password: "correct horse", wantCodes: []string{PasswordBreached}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violations, err := policy.Validate(tt.password, "walter.white@example.com")
+			if err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+			var gotCodes []string
+			for _, v := range violations {
+				gotCodes = append(gotCodes, v.Code)
+			}
+			if !slices.Equal(gotCodes, tt.wantCodes) {
+				t.Errorf("Validate() codes = %v, want %v", gotCodes, tt.wantCodes)
+			}
+		})
+	}
+
+	// Every line has to be found, including the first and the last.
+	for _, password := range breachedPasswords {
+		if found, err := breached.Contains(password); err != nil || !found {
+			t.Errorf("Contains(%q) = %v, %v, want true", password, found, err)
+		}
+	}
+}
+
+func TestRoleCan(t *testing.T) {
+	tests := []struct {
+		name       string
+		role       Role
+		permission Permission
+		want       bool
+	}{
+		{
+			name:       "Admin can reset the database",
+			role:       RoleAdmin,
+			permission: PermissionResetDatabase,
+			want:       true,
+		},
+		{
+			name:       "Moderator can delete any chirp",
+			role:       RoleModerator,
+			permission: PermissionDeleteAnyChirp,
+			want:       true,
+		},
+		{
+			name:       "Moderator can't manage roles",
+			role:       RoleModerator,
+			permission: PermissionManageRoles,
+			want:       false,
+		},
+		{
+			name:       "User can't view metrics",
+			role:       RoleUser,
+			permission: PermissionViewMetrics,
+			want:       false,
+		},
+		{
+			name:       "Token without a role",
+			role:       "",
+			permission: PermissionViewMetrics,
+			want:       false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.role.Can(tt.permission); got != tt.want {
+				t.Errorf("Role.Can() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyPKCE(t *testing.T) {
+	verifier := "dBjftJeZ4CVP-mJ92K9hgD3N8tDHhhCmJOWKd9gRi5A"
+	challenge := "Woo7_MhmPxz_I9rV7ho3IPKzfdElRhZkuIWmIJMcZk0"
+
+	tests := []struct {
+		name      string
+		verifier  string
+		challenge string
+		want      bool
+	}{
+		{
+			name:      "Matching verifier",
+			verifier:  verifier,
+			challenge: challenge,
+			want:      true,
+		},
+		{
+			name:      "Wrong verifier",
+			verifier:  verifier + "x",
+			challenge: challenge,
+			want:      false,
+		},
+		{
+			name:      "Plain challenge",
+			verifier:  verifier,
+			challenge: verifier,
+			want:      false,
+		},
+		{
+			name:      "Verifier too short",
+			verifier:  "short",
+			challenge: challenge,
+			want:      false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := VerifyPKCE(tt.verifier, tt.challenge); got != tt.want {
+				t.Errorf("VerifyPKCE() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOAuthAccessToken(t *testing.T) {
+	keys := NewHMACKeySet("secret")
+	userID := uuid.New()
+	clientID := uuid.New()
+
+	token, err := MakeOAuthAccessToken(userID, clientID, ScopeChirpsRead, keys, time.Hour)
+	if err != nil {
+		t.Fatalf("MakeOAuthAccessToken() error = %v", err)
+	}
+
+	claims, err := ParseOAuthAccessToken(token, keys)
+	if err != nil {
+		t.Fatalf("ParseOAuthAccessToken() error = %v", err)
+	}
+	if claims.Subject != userID.String() || claims.ClientID != clientID.String() || claims.Scope != ScopeChirpsRead {
+		t.Errorf("ParseOAuthAccessToken() = %+v", claims)
+	}
+
+	// OAuth tokens must never pass as first-party access tokens.
+	if _, _, err := ValidateJWT(token, keys); err == nil {
+		t.Error("ValidateJWT() accepted an OAuth access token")
+	}
+}
+
 func TestValidateJWT(t *testing.T) {
 	userID := uuid.New()
-	validToken, _ := MakeJWT(userID, "secret", time.Hour)
+	adminID := uuid.New()
+	validToken, _ := MakeJWT(userID, NewHMACKeySet("secret"), time.Hour)
+	impersonatedToken, _ := MakeJWT(userID, NewHMACKeySet("secret"), time.Hour, WithActor(adminID))
 
 	tests := []struct {
 		name        string
 		tokenString string
 		tokenSecret string
 		wantUserID  uuid.UUID
+		wantActorID uuid.UUID
 		wantErr     bool
 	}{
 		{
@@ -81,6 +318,14 @@ func TestValidateJWT(t *testing.T) {
 			wantUserID:  userID,
 			wantErr:     false,
 		},
+		{
+			name:        "Impersonated token",
+			tokenString: impersonatedToken,
+			tokenSecret: "secret",
+			wantUserID:  userID,
+			wantActorID: adminID,
+			wantErr:     false,
+		},
 		{
 			name:        "Invalid token",
 			tokenString: "invalid.token.string",
@@ -99,7 +344,7 @@ func TestValidateJWT(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gotUserID, err := ValidateJWT(tt.tokenString, tt.tokenSecret)
+			gotUserID, gotActorID, err := ValidateJWT(tt.tokenString, NewHMACKeySet(tt.tokenSecret))
 			if (err != nil) != tt.wantErr {
 				t.Errorf("ValidateJWT() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -107,6 +352,9 @@ func TestValidateJWT(t *testing.T) {
 			if gotUserID != tt.wantUserID {
 				t.Errorf("ValidateJWT() gotUserID = %v, want %v", gotUserID, tt.wantUserID)
 			}
+			if gotActorID != tt.wantActorID {
+				t.Errorf("ValidateJWT() gotActorID = %v, want %v", gotActorID, tt.wantActorID)
+			}
 		})
 	}
 }
@@ -155,3 +403,142 @@ func TestGetBearerToken(t *testing.T) {
 		})
 	}
 }
+
+func TestKeySetRotation(t *testing.T) {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	keys := NewKeySet()
+	if err := keys.AddPrivateKey("ed-1", edKey); err != nil {
+		t.Fatalf("AddPrivateKey() error = %v", err)
+	}
+	if err := keys.AddPrivateKey("rsa-2", rsaKey); err != nil {
+		t.Fatalf("AddPrivateKey() error = %v", err)
+	}
+	keys.AddHMACKey("hs256", "secret")
+
+	userID := uuid.New()
+	legacyToken, _ := MakeJWT(userID, NewHMACKeySet("secret"), time.Hour)
+
+	keys.SetActive("ed-1")
+	oldToken, _ := MakeJWT(userID, keys, time.Hour)
+	keys.SetActive("rsa-2")
+	newToken, _ := MakeJWT(userID, keys, time.Hour)
+
+	_, otherEdKey, _ := ed25519.GenerateKey(rand.Reader)
+	otherKeys := NewKeySet()
+	otherKeys.AddPrivateKey("ed-1", otherEdKey)
+	otherKeys.SetActive("ed-1")
+	forgedToken, _ := MakeJWT(userID, otherKeys, time.Hour)
+
+	tests := []struct {
+		name        string
+		tokenString string
+		wantErr     bool
+	}{
+		{
+			name:        "Token signed with the active key",
+			tokenString: newToken,
+			wantErr:     false,
+		},
+		{
+			name:        "Token signed with a rotated key",
+			tokenString: oldToken,
+			wantErr:     false,
+		},
+		{
+			name:        "Token signed before key IDs existed",
+			tokenString: legacyToken,
+			wantErr:     false,
+		},
+		{
+			name:        "Token signed with an unknown key under a known ID",
+			tokenString: forgedToken,
+			wantErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotUserID, _, err := ValidateJWT(tt.tokenString, keys)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateJWT() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && gotUserID != userID {
+				t.Errorf("ValidateJWT() gotUserID = %v, want %v", gotUserID, userID)
+			}
+		})
+	}
+
+	jwks := keys.JWKS()
+	if len(jwks.Keys) != 2 {
+		t.Fatalf("JWKS() returned %d keys, want 2", len(jwks.Keys))
+	}
+	if jwks.Keys[0].Kid != "ed-1" || jwks.Keys[0].Kty != "OKP" {
+		t.Errorf("JWKS() first key = %+v, want OKP key ed-1", jwks.Keys[0])
+	}
+	if jwks.Keys[1].Kid != "rsa-2" || jwks.Keys[1].Kty != "RSA" {
+		t.Errorf("JWKS() second key = %+v, want RSA key rsa-2", jwks.Keys[1])
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	// RFC 6238 test secret "12345678901234567890", base32 encoded.
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+	tests := []struct {
+		name     string
+		code     string
+		time     time.Time
+		wantStep int64
+		wantOK   bool
+	}{
+		{
+			name:     "RFC 6238 vector at 59s",
+			code:     "287082",
+			time:     time.Unix(59, 0),
+			wantStep: 1,
+			wantOK:   true,
+		},
+		{
+			name:     "RFC 6238 vector at 1111111109s",
+			code:     "081804",
+			time:     time.Unix(1111111109, 0),
+			wantStep: 37037036,
+			wantOK:   true,
+		},
+		{
+			name:     "Previous period is still accepted",
+			code:     "287082",
+			time:     time.Unix(89, 0),
+			wantStep: 1,
+			wantOK:   true,
+		},
+		{
+			name:   "Code from too long ago",
+			code:   "287082",
+			time:   time.Unix(120, 0),
+			wantOK: false,
+		},
+		{
+			name:   "Wrong code",
+			code:   "123456",
+			time:   time.Unix(59, 0),
+			wantOK: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotStep, gotOK := ValidateTOTP(tt.code, secret, tt.time)
+			if gotOK != tt.wantOK {
+				t.Errorf("ValidateTOTP() ok = %v, want %v", gotOK, tt.wantOK)
+				return
+			}
+			if gotOK && gotStep != tt.wantStep {
+				t.Errorf("ValidateTOTP() step = %v, want %v", gotStep, tt.wantStep)
+			}
+		})
+	}
+}
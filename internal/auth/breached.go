@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// BreachedPasswordFile checks passwords against a file of SHA-1 hashes sorted
+// in ascending order, one per line, such as the Have I Been Pwned "ordered
+// by hash" download. Lines are "HASH" or "HASH:COUNT". The file is binary
+// searched in place, so it can be much larger than memory.
+type BreachedPasswordFile struct {
+	f    *os.File
+	size int64
+}
+
+func OpenBreachedPasswordFile(path string) (*BreachedPasswordFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &BreachedPasswordFile{
+		f:    f,
+		size: info.Size(),
+	}, nil
+}
+
+func (b *BreachedPasswordFile) Close() error {
+	return b.f.Close()
+}
+
+// Contains reports whether the password's SHA-1 hash is in the file.
+func (b *BreachedPasswordFile) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	target := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	// Find the line with the target hash. lo and hi are byte offsets; any
+	// line holding the target starts in [lo, hi).
+	lo, hi := int64(0), b.size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		hash, next, err := b.lineAt(mid)
+		if errors.Is(err, io.EOF) {
+			hi = mid
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+
+		switch {
+		case hash == target:
+			return true, nil
+		case hash < target:
+			lo = next
+		default:
+			hi = mid
+		}
+	}
+	return false, nil
+}
+
+// lineAt returns the hash on the first line starting at or after off, and
+// the offset of the line after it. It returns io.EOF if no line starts there.
+func (b *BreachedPasswordFile) lineAt(off int64) (string, int64, error) {
+	start := off
+	if off > 0 {
+		// Look at the byte before off to tell whether a line starts at off.
+		start = off - 1
+	}
+	r := bufio.NewReaderSize(io.NewSectionReader(b.f, start, b.size-start), 128)
+
+	pos := start
+	if off > 0 {
+		skipped, err := r.ReadSlice('\n')
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return "", 0, io.EOF
+			}
+			return "", 0, fmt.Errorf("reading breached password file: %w", err)
+		}
+		pos += int64(len(skipped))
+	}
+
+	line, err := r.ReadSlice('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", 0, fmt.Errorf("reading breached password file: %w", err)
+	}
+	if len(line) == 0 {
+		return "", 0, io.EOF
+	}
+	next := pos + int64(len(line))
+
+	hash, _, _ := strings.Cut(strings.TrimSpace(string(line)), ":")
+	return strings.ToUpper(hash), next, nil
+}
@@ -0,0 +1,235 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// SigningKey is a single key in a KeySet. Keys loaded from a public key only
+// can verify tokens but never sign them.
+type SigningKey struct {
+	ID        string
+	Method    jwt.SigningMethod
+	signKey   any
+	verifyKey any
+}
+
+// KeySet holds every key that access tokens may be verified with. New tokens
+// are always signed with the active key, so rotating means adding a new key,
+// making it active and keeping the old one around until its tokens expire.
+type KeySet struct {
+	keys   map[string]*SigningKey
+	active string
+	// hmacKID is the HMAC key used for tokens minted before key IDs existed.
+	hmacKID string
+	// revocations is checked for every access token that verifies.
+	revocations RevocationChecker
+}
+
+// JWK is the public part of a key as published in the JWKS document.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKS is the document served at /.well-known/jwks.json.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+func NewKeySet() *KeySet {
+	return &KeySet{
+		keys: map[string]*SigningKey{},
+	}
+}
+
+// NewHMACKeySet returns a key set that signs with HS256 using a shared secret.
+func NewHMACKeySet(secret string) *KeySet {
+	ks := NewKeySet()
+	ks.AddHMACKey("hs256", secret)
+	ks.active = "hs256"
+	return ks
+}
+
+// LoadKeySet reads every PEM file in dir. Files named <kid>.pem hold a PKCS#8
+// private key, files named <kid>.pub.pem hold a PKIX public key of a retired
+// key that is still accepted for verification.
+func LoadKeySet(dir, activeKID string) (*KeySet, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	ks := NewKeySet()
+	var privateKIDs []string
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("%s: no PEM data found", path)
+		}
+
+		name := filepath.Base(path)
+		if kid, ok := strings.CutSuffix(name, ".pub.pem"); ok {
+			key, err := x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			if err := ks.AddPublicKey(kid, key); err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			continue
+		}
+
+		kid := strings.TrimSuffix(name, ".pem")
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if err := ks.AddPrivateKey(kid, key); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		privateKIDs = append(privateKIDs, kid)
+	}
+
+	if activeKID == "" {
+		if len(privateKIDs) != 1 {
+			return nil, fmt.Errorf("found %d private keys in %s, the active key ID must be set", len(privateKIDs), dir)
+		}
+		activeKID = privateKIDs[0]
+	}
+	if err := ks.SetActive(activeKID); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// AddPrivateKey adds a key that can sign tokens. RSA keys sign with RS256,
+// Ed25519 keys with EdDSA.
+func (ks *KeySet) AddPrivateKey(kid string, key crypto.PrivateKey) error {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < 2048 {
+			return errors.New("RSA keys must be at least 2048 bits")
+		}
+		ks.keys[kid] = &SigningKey{ID: kid, Method: jwt.SigningMethodRS256, signKey: k, verifyKey: &k.PublicKey}
+	case ed25519.PrivateKey:
+		ks.keys[kid] = &SigningKey{ID: kid, Method: jwt.SigningMethodEdDSA, signKey: k, verifyKey: k.Public()}
+	default:
+		return fmt.Errorf("unsupported private key type %T", key)
+	}
+	return nil
+}
+
+// AddPublicKey adds a key that can only verify tokens.
+func (ks *KeySet) AddPublicKey(kid string, key crypto.PublicKey) error {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		ks.keys[kid] = &SigningKey{ID: kid, Method: jwt.SigningMethodRS256, verifyKey: k}
+	case ed25519.PublicKey:
+		ks.keys[kid] = &SigningKey{ID: kid, Method: jwt.SigningMethodEdDSA, verifyKey: k}
+	default:
+		return fmt.Errorf("unsupported public key type %T", key)
+	}
+	return nil
+}
+
+// AddHMACKey adds an HS256 key. It also verifies tokens without a kid header,
+// which keeps tokens signed before the switch to key IDs valid. HMAC keys are
+// never published in the JWKS.
+func (ks *KeySet) AddHMACKey(kid, secret string) {
+	ks.keys[kid] = &SigningKey{ID: kid, Method: jwt.SigningMethodHS256, signKey: []byte(secret), verifyKey: []byte(secret)}
+	ks.hmacKID = kid
+}
+
+// SetActive selects the key new tokens are signed with.
+func (ks *KeySet) SetActive(kid string) error {
+	key, ok := ks.keys[kid]
+	if !ok {
+		return fmt.Errorf("unknown key ID %q", kid)
+	}
+	if key.signKey == nil {
+		return fmt.Errorf("key %q has no private key", kid)
+	}
+	ks.active = kid
+	return nil
+}
+
+func (ks *KeySet) sign(claims jwt.Claims) (string, error) {
+	key, ok := ks.keys[ks.active]
+	if !ok {
+		return "", errors.New("no active signing key")
+	}
+
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.signKey)
+}
+
+func (ks *KeySet) keyfunc(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		kid = ks.hmacKID
+	}
+
+	key, ok := ks.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key ID %q", kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s for key %q", token.Method.Alg(), kid)
+	}
+	return key.verifyKey, nil
+}
+
+// JWKS returns the public keys of the set, sorted by key ID.
+func (ks *KeySet) JWKS() JWKS {
+	kids := make([]string, 0, len(ks.keys))
+	for kid := range ks.keys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	jwks := JWKS{Keys: []JWK{}}
+	for _, kid := range kids {
+		key := ks.keys[kid]
+		jwk := JWK{Kid: kid, Use: "sig", Alg: key.Method.Alg()}
+		switch k := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(k.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(k)
+		default:
+			continue
+		}
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	return jwks
+}
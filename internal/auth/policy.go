@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Codes of the ways a password can break the policy.
+const (
+	PasswordTooShort     = "too_short"
+	PasswordTooLong      = "too_long"
+	PasswordMatchesEmail = "matches_email"
+	PasswordBreached     = "breached"
+)
+
+// PasswordViolation is one way a password breaks the policy.
+type PasswordViolation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// BreachedPasswordList reports whether a password appears in a known breach.
+type BreachedPasswordList interface {
+	Contains(password string) (bool, error)
+}
+
+// PasswordPolicy decides which new passwords are accepted.
+type PasswordPolicy struct {
+	// MinLength is counted in characters.
+	MinLength int
+	// MaxLength is counted in bytes. It defaults to bcrypt's limit, beyond
+	// which bcrypt silently ignores the rest of the password.
+	MaxLength int
+	// Breached is optional.
+	Breached BreachedPasswordList
+}
+
+var DefaultPasswordPolicy = PasswordPolicy{
+	MinLength: 8,
+	MaxLength: 72,
+}
+
+// Validate returns every rule the password breaks, or nothing if it is
+// acceptable for the account with the given email. The error is only set
+// when the breached password list couldn't be checked.
+func (p PasswordPolicy) Validate(password, email string) ([]PasswordViolation, error) {
+	violations := []PasswordViolation{}
+	if utf8.RuneCountInString(password) < p.MinLength {
+		violations = append(violations, PasswordViolation{
+			Code:    PasswordTooShort,
+			Message: fmt.Sprintf("Password must be at least %d characters", p.MinLength),
+		})
+	}
+	if len(password) > p.MaxLength {
+		violations = append(violations, PasswordViolation{
+			Code:    PasswordTooLong,
+			Message: fmt.Sprintf("Password must be at most %d bytes", p.MaxLength),
+		})
+	}
+	if matchesEmail(password, email) {
+		violations = append(violations, PasswordViolation{
+			Code:    PasswordMatchesEmail,
+			Message: "Password must not be your email address",
+		})
+	}
+	if len(violations) > 0 || p.Breached == nil {
+		return violations, nil
+	}
+
+	breached, err := p.Breached.Contains(password)
+	if err != nil {
+		return violations, err
+	}
+	if breached {
+		violations = append(violations, PasswordViolation{
+			Code:    PasswordBreached,
+			Message: "Password has appeared in a data breach, choose another one",
+		})
+	}
+	return violations, nil
+}
+
+// matchesEmail compares the password to the whole address and to the part
+// before the @, ignoring case.
+func matchesEmail(password, email string) bool {
+	password = strings.ToLower(strings.TrimSpace(password))
+	email = strings.ToLower(strings.TrimSpace(email))
+	if password == "" || email == "" {
+		return false
+	}
+	local, _, _ := strings.Cut(email, "@")
+	return password == email || password == local
+}
+
+// ValidatePassword checks a new password against DefaultPasswordPolicy.
+func ValidatePassword(password, email string) ([]PasswordViolation, error) {
+	return DefaultPasswordPolicy.Validate(password, email)
+}
@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	UserID uuid.UUID
+	// Role is only taken from first-party access tokens. Other credentials
+	// always act as a plain user.
+	Role Role
+	// Scopes the credential was granted. First-party access tokens have
+	// every scope.
+	Scopes    []string
+	TokenType TokenType
+	// TokenID and ExpiresAt identify an access token for revocation.
+	TokenID   string
+	ExpiresAt time.Time
+	// SessionID is the session an access token was issued for, if any.
+	SessionID uuid.UUID
+	// ActorID is the admin acting as the user, if the token is impersonated.
+	ActorID uuid.UUID
+	// ClientID is the OAuth client the token was issued to.
+	ClientID uuid.UUID
+}
+
+func (p *Principal) HasScope(scope string) bool {
+	return slices.Contains(p.Scopes, scope)
+}
+
+// Impersonated reports whether an admin is acting as the user.
+func (p *Principal) Impersonated() bool {
+	return p.ActorID != uuid.Nil
+}
+
+// FirstParty reports whether the principal logged in to Chirpy itself,
+// rather than using a personal access token or a third-party app.
+func (p *Principal) FirstParty() bool {
+	return p.TokenType == TokenTypeAccess
+}
+
+type principalKey struct{}
+
+// ContextWithPrincipal returns a copy of ctx carrying the principal.
+func ContextWithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFromContext returns the principal stored by the authentication
+// middleware, if the request was authenticated.
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok && p != nil
+}
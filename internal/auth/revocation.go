@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+var ErrTokenRevoked = errors.New("token has been revoked")
+
+// RevocationChecker decides whether an access token was revoked before it
+// expired, either on its own by its ID or along with every token its user
+// held at some point. It is consulted on every request, so it must not block.
+type RevocationChecker interface {
+	IsRevoked(tokenID string, userID uuid.UUID, issuedAt time.Time) bool
+}
+
+// SetRevocationChecker makes ParseAccessToken and ParseOAuthAccessToken
+// reject revoked tokens.
+func (ks *KeySet) SetRevocationChecker(checker RevocationChecker) {
+	ks.revocations = checker
+}
+
+func (ks *KeySet) checkRevoked(claims jwt.RegisteredClaims) error {
+	if ks.revocations == nil {
+		return nil
+	}
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return fmt.Errorf("invalid user ID: %w", err)
+	}
+	var issuedAt time.Time
+	if claims.IssuedAt != nil {
+		issuedAt = claims.IssuedAt.Time
+	}
+	if ks.revocations.IsRevoked(claims.ID, userID, issuedAt) {
+		return ErrTokenRevoked
+	}
+	return nil
+}
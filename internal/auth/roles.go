@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Role is a user's role, stored on the user and carried in access tokens.
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+// Permission is an action that only some roles may perform.
+type Permission string
+
+const (
+	PermissionViewMetrics        Permission = "metrics:view"
+	PermissionResetDatabase      Permission = "database:reset"
+	PermissionClearLockouts      Permission = "lockouts:clear"
+	PermissionDeleteAnyChirp     Permission = "chirps:delete-any"
+	PermissionManageRoles        Permission = "roles:manage"
+	PermissionRevokeTokens       Permission = "tokens:revoke"
+	PermissionImpersonate        Permission = "users:impersonate"
+	PermissionViewAuditLog       Permission = "audit-log:view"
+	PermissionViewSecurityEvents Permission = "security-events:view"
+)
+
+var rolePermissions = map[Role][]Permission{
+	RoleUser: {},
+	RoleModerator: {
+		PermissionClearLockouts,
+		PermissionDeleteAnyChirp,
+		PermissionRevokeTokens,
+		PermissionViewSecurityEvents,
+	},
+	RoleAdmin: {
+		PermissionViewMetrics,
+		PermissionResetDatabase,
+		PermissionClearLockouts,
+		PermissionDeleteAnyChirp,
+		PermissionManageRoles,
+		PermissionRevokeTokens,
+		PermissionImpersonate,
+		PermissionViewAuditLog,
+		PermissionViewSecurityEvents,
+	},
+}
+
+func ParseRole(s string) (Role, error) {
+	role := Role(s)
+	if _, ok := rolePermissions[role]; !ok {
+		return "", fmt.Errorf("unknown role %q", s)
+	}
+	return role, nil
+}
+
+// Can reports whether the role grants permission. Unknown roles, including
+// the empty role of tokens issued before roles existed, grant nothing.
+func (r Role) Can(permission Permission) bool {
+	return slices.Contains(rolePermissions[r], permission)
+}
@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"slices"
+	"strings"
+)
+
+// PersonalAccessTokenPrefix marks personal access tokens so they can be told
+// apart from JWTs in the Authorization header and spotted by secret scanners.
+const PersonalAccessTokenPrefix = "chirpy_pat_"
+
+const (
+	ScopeChirpsRead  = "chirps:read"
+	ScopeChirpsWrite = "chirps:write"
+)
+
+// Scopes lists every scope a personal access token can be granted.
+var Scopes = []string{
+	ScopeChirpsRead,
+	ScopeChirpsWrite,
+}
+
+// MakePersonalAccessToken returns a new random personal access token. Only
+// its HashToken digest should be stored.
+func MakePersonalAccessToken() (string, error) {
+	token, err := MakeOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	return PersonalAccessTokenPrefix + token, nil
+}
+
+func IsPersonalAccessToken(token string) bool {
+	return strings.HasPrefix(token, PersonalAccessTokenPrefix)
+}
+
+// ParseScopes splits a space separated scope string, as stored in the
+// database, into its scopes.
+func ParseScopes(s string) []string {
+	return strings.Fields(s)
+}
+
+// HasScope reports whether the space separated scope string grants scope.
+func HasScope(scopes, scope string) bool {
+	return slices.Contains(ParseScopes(scopes), scope)
+}
@@ -7,29 +7,54 @@ package database
 
 import (
 	"context"
+	"database/sql"
+	"time"
 
 	"github.com/google/uuid"
 )
 
+const chirpExists = `-- name: ChirpExists :one
+SELECT EXISTS (SELECT 1 FROM chirps WHERE chirps.id = $1)
+`
+
+func (q *Queries) ChirpExists(ctx context.Context, id uuid.UUID) (bool, error) {
+	row := q.db.QueryRowContext(ctx, chirpExists, id)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
 const createChirp = `-- name: CreateChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, lang, in_reply_to, root_id)
 VALUES (
     gen_random_uuid(),
     NOW(),
     NOW(),
     $1,
-    $2
+    $2,
+    $3,
+    $4,
+    $5
 )
-RETURNING id, created_at, updated_at, body, user_id
+RETURNING id, created_at, updated_at, body, user_id, search_vector, lang, in_reply_to, root_id, deleted_at
 `
 
 type CreateChirpParams struct {
-	Body   string
-	UserID uuid.UUID
+	Body      string
+	UserID    uuid.UUID
+	Lang      sql.NullString
+	InReplyTo uuid.NullUUID
+	RootID    uuid.NullUUID
 }
 
 func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp, error) {
-	row := q.db.QueryRowContext(ctx, createChirp, arg.Body, arg.UserID)
+	row := q.db.QueryRowContext(ctx, createChirp,
+		arg.Body,
+		arg.UserID,
+		arg.Lang,
+		arg.InReplyTo,
+		arg.RootID,
+	)
 	var i Chirp
 	err := row.Scan(
 		&i.ID,
@@ -37,23 +62,63 @@ func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.SearchVector,
+		&i.Lang,
+		&i.InReplyTo,
+		&i.RootID,
+		&i.DeletedAt,
 	)
 	return i, err
 }
 
-const deleteChirp = `-- name: DeleteChirp :exec
+const deleteChirp = `-- name: DeleteChirp :one
 DELETE FROM chirps
 WHERE id = $1
+RETURNING in_reply_to
 `
 
-func (q *Queries) DeleteChirp(ctx context.Context, id uuid.UUID) error {
-	_, err := q.db.ExecContext(ctx, deleteChirp, id)
-	return err
+func (q *Queries) DeleteChirp(ctx context.Context, id uuid.UUID) (uuid.NullUUID, error) {
+	row := q.db.QueryRowContext(ctx, deleteChirp, id)
+	var inReplyTo uuid.NullUUID
+	err := row.Scan(&inReplyTo)
+	return inReplyTo, err
 }
 
-const getChirp = `-- name: GetChirp :one
-SELECT id, created_at, updated_at, body, user_id FROM chirps
+const deleteEmptyTombstone = `-- name: DeleteEmptyTombstone :one
+DELETE FROM chirps
 WHERE id = $1
+AND deleted_at IS NOT NULL
+AND NOT EXISTS (SELECT 1 FROM chirps replies WHERE replies.in_reply_to = chirps.id)
+RETURNING in_reply_to
+`
+
+func (q *Queries) DeleteEmptyTombstone(ctx context.Context, id uuid.UUID) (uuid.NullUUID, error) {
+	row := q.db.QueryRowContext(ctx, deleteEmptyTombstone, id)
+	var inReplyTo uuid.NullUUID
+	err := row.Scan(&inReplyTo)
+	return inReplyTo, err
+}
+
+const deleteEmptyTombstones = `-- name: DeleteEmptyTombstones :execrows
+DELETE FROM chirps
+WHERE deleted_at IS NOT NULL
+AND NOT EXISTS (SELECT 1 FROM chirps replies WHERE replies.in_reply_to = chirps.id)
+`
+
+func (q *Queries) DeleteEmptyTombstones(ctx context.Context) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteEmptyTombstones)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const getChirp = `-- name: GetChirp :one
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.search_vector, chirps.lang, chirps.in_reply_to, chirps.root_id, chirps.deleted_at FROM chirps
+JOIN users ON users.id = chirps.user_id
+WHERE chirps.id = $1
+AND chirps.deleted_at IS NULL
+AND users.deactivated_at IS NULL
 `
 
 func (q *Queries) GetChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
@@ -65,13 +130,21 @@ func (q *Queries) GetChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.SearchVector,
+		&i.Lang,
+		&i.InReplyTo,
+		&i.RootID,
+		&i.DeletedAt,
 	)
 	return i, err
 }
 
 const getChirpByID = `-- name: GetChirpByID :one
-SELECT id, created_at, updated_at, body, user_id FROM chirps
-WHERE id = $1
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.search_vector, chirps.lang, chirps.in_reply_to, chirps.root_id, chirps.deleted_at FROM chirps
+JOIN users ON users.id = chirps.user_id
+WHERE chirps.id = $1
+AND chirps.deleted_at IS NULL
+AND users.deactivated_at IS NULL
 `
 
 func (q *Queries) GetChirpByID(ctx context.Context, id uuid.UUID) (Chirp, error) {
@@ -83,17 +156,183 @@ func (q *Queries) GetChirpByID(ctx context.Context, id uuid.UUID) (Chirp, error)
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.SearchVector,
+		&i.Lang,
+		&i.InReplyTo,
+		&i.RootID,
+		&i.DeletedAt,
 	)
 	return i, err
 }
 
-const getChirps = `-- name: GetChirps :many
-SELECT id, created_at, updated_at, body, user_id FROM chirps
-ORDER BY created_at ASC
+const getChirpRevisions = `-- name: GetChirpRevisions :many
+SELECT id, chirp_id, body, created_at, replaced_at FROM chirp_revisions
+WHERE chirp_id = $1
+ORDER BY replaced_at DESC, id DESC
+`
+
+func (q *Queries) GetChirpRevisions(ctx context.Context, chirpID uuid.UUID) ([]ChirpRevision, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpRevisions, chirpID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ChirpRevision
+	for rows.Next() {
+		var i ChirpRevision
+		if err := rows.Scan(
+			&i.ID,
+			&i.ChirpID,
+			&i.Body,
+			&i.CreatedAt,
+			&i.ReplacedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getChirpThread = `-- name: GetChirpThread :many
+WITH RECURSIVE ancestors AS (
+    SELECT chirps.id, chirps.in_reply_to FROM chirps
+    WHERE chirps.id = $1::uuid
+    UNION ALL
+    SELECT parent.id, parent.in_reply_to FROM chirps parent
+    JOIN ancestors ON parent.id = ancestors.in_reply_to
+), descendants AS (
+    -- Walked a level at a time and only as far as the LIMIT below reads, so
+    -- a large conversation isn't scanned in full.
+    SELECT chirps.id FROM chirps
+    WHERE chirps.in_reply_to = $1::uuid
+    UNION ALL
+    SELECT reply.id FROM chirps reply
+    JOIN descendants ON reply.in_reply_to = descendants.id
+)
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.lang,
+    chirps.in_reply_to, chirps.root_id, chirps.deleted_at,
+    users.deactivated_at IS NOT NULL AS author_deactivated
+FROM chirps
+JOIN users ON users.id = chirps.user_id
+WHERE chirps.id IN (SELECT ancestors.id FROM ancestors UNION ALL (SELECT descendants.id FROM descendants LIMIT $2::int))
+ORDER BY chirps.created_at ASC, chirps.id ASC
+LIMIT $2::int
+`
+
+type GetChirpThreadRow struct {
+	ID                uuid.UUID
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+	Body              string
+	UserID            uuid.UUID
+	Lang              sql.NullString
+	InReplyTo         uuid.NullUUID
+	RootID            uuid.NullUUID
+	DeletedAt         sql.NullTime
+	AuthorDeactivated bool
+}
+
+type GetChirpThreadParams struct {
+	ChirpID  uuid.UUID
+	RowLimit int32
+}
+
+func (q *Queries) GetChirpThread(ctx context.Context, arg GetChirpThreadParams) ([]GetChirpThreadRow, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpThread, arg.ChirpID, arg.RowLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetChirpThreadRow
+	for rows.Next() {
+		var i GetChirpThreadRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.Lang,
+			&i.InReplyTo,
+			&i.RootID,
+			&i.DeletedAt,
+			&i.AuthorDeactivated,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const handOverRepliedChirps = `-- name: HandOverRepliedChirps :execrows
+WITH revisions AS (
+    DELETE FROM chirp_revisions
+    USING chirps, users
+    WHERE chirp_revisions.chirp_id = chirps.id
+    AND users.id = chirps.user_id
+    AND users.deactivated_at < $1::timestamp
+    AND EXISTS (SELECT 1 FROM chirps replies WHERE replies.in_reply_to = chirps.id)
+)
+UPDATE chirps
+SET user_id = $2::uuid, body = '', lang = NULL, deleted_at = COALESCE(chirps.deleted_at, NOW())
+FROM users
+WHERE users.id = chirps.user_id
+AND users.deactivated_at < $1::timestamp
+AND EXISTS (SELECT 1 FROM chirps replies WHERE replies.in_reply_to = chirps.id)
 `
 
-func (q *Queries) GetChirps(ctx context.Context) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, getChirps)
+type HandOverRepliedChirpsParams struct {
+	DeactivatedBefore time.Time
+	DeletedAuthorID   uuid.UUID
+}
+
+func (q *Queries) HandOverRepliedChirps(ctx context.Context, arg HandOverRepliedChirpsParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, handOverRepliedChirps, arg.DeactivatedBefore, arg.DeletedAuthorID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const listChirpsAfter = `-- name: ListChirpsAfter :many
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.search_vector, chirps.lang, chirps.in_reply_to, chirps.root_id, chirps.deleted_at FROM chirps
+JOIN users ON users.id = chirps.user_id
+WHERE users.deactivated_at IS NULL
+AND chirps.deleted_at IS NULL
+AND ($1::uuid IS NULL OR chirps.user_id = $1)
+AND ($2::timestamp IS NULL OR (chirps.created_at, chirps.id) > ($2, $3::uuid))
+ORDER BY chirps.created_at ASC, chirps.id ASC
+LIMIT $4::int
+`
+
+type ListChirpsAfterParams struct {
+	AuthorID        uuid.NullUUID
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	RowLimit        int32
+}
+
+func (q *Queries) ListChirpsAfter(ctx context.Context, arg ListChirpsAfterParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, listChirpsAfter,
+		arg.AuthorID,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.RowLimit,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -107,6 +346,11 @@ func (q *Queries) GetChirps(ctx context.Context) ([]Chirp, error) {
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.SearchVector,
+			&i.Lang,
+			&i.InReplyTo,
+			&i.RootID,
+			&i.DeletedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -121,14 +365,31 @@ func (q *Queries) GetChirps(ctx context.Context) ([]Chirp, error) {
 	return items, nil
 }
 
-const getChirpsByAuthorID = `-- name: GetChirpsByAuthorID :many
-SELECT id, created_at, updated_at, body, user_id FROM chirps
-WHERE user_id = $1
-ORDER BY created_at ASC
+const listChirpsBefore = `-- name: ListChirpsBefore :many
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.search_vector, chirps.lang, chirps.in_reply_to, chirps.root_id, chirps.deleted_at FROM chirps
+JOIN users ON users.id = chirps.user_id
+WHERE users.deactivated_at IS NULL
+AND chirps.deleted_at IS NULL
+AND ($1::uuid IS NULL OR chirps.user_id = $1)
+AND ($2::timestamp IS NULL OR (chirps.created_at, chirps.id) < ($2, $3::uuid))
+ORDER BY chirps.created_at DESC, chirps.id DESC
+LIMIT $4::int
 `
 
-func (q *Queries) GetChirpsByAuthorID(ctx context.Context, userID uuid.UUID) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, getChirpsByAuthorID, userID)
+type ListChirpsBeforeParams struct {
+	AuthorID        uuid.NullUUID
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	RowLimit        int32
+}
+
+func (q *Queries) ListChirpsBefore(ctx context.Context, arg ListChirpsBeforeParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, listChirpsBefore,
+		arg.AuthorID,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.RowLimit,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -142,6 +403,174 @@ func (q *Queries) GetChirpsByAuthorID(ctx context.Context, userID uuid.UUID) ([]
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.SearchVector,
+			&i.Lang,
+			&i.InReplyTo,
+			&i.RootID,
+			&i.DeletedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listRepliesAfter = `-- name: ListRepliesAfter :many
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.lang,
+    chirps.in_reply_to, chirps.root_id, chirps.deleted_at,
+    users.deactivated_at IS NOT NULL AS author_deactivated
+FROM chirps
+JOIN users ON users.id = chirps.user_id
+WHERE chirps.in_reply_to = $1::uuid
+AND ($2::timestamp IS NULL OR (chirps.created_at, chirps.id) > ($2, $3::uuid))
+ORDER BY chirps.created_at ASC, chirps.id ASC
+LIMIT $4::int
+`
+
+type ListRepliesAfterRow struct {
+	ID                uuid.UUID
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+	Body              string
+	UserID            uuid.UUID
+	Lang              sql.NullString
+	InReplyTo         uuid.NullUUID
+	RootID            uuid.NullUUID
+	DeletedAt         sql.NullTime
+	AuthorDeactivated bool
+}
+
+type ListRepliesAfterParams struct {
+	ParentID        uuid.UUID
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	RowLimit        int32
+}
+
+func (q *Queries) ListRepliesAfter(ctx context.Context, arg ListRepliesAfterParams) ([]ListRepliesAfterRow, error) {
+	rows, err := q.db.QueryContext(ctx, listRepliesAfter,
+		arg.ParentID,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.RowLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListRepliesAfterRow
+	for rows.Next() {
+		var i ListRepliesAfterRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.Lang,
+			&i.InReplyTo,
+			&i.RootID,
+			&i.DeletedAt,
+			&i.AuthorDeactivated,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const lockChirp = `-- name: LockChirp :exec
+SELECT id FROM chirps
+WHERE id = $1
+FOR UPDATE
+`
+
+func (q *Queries) LockChirp(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, lockChirp, id)
+	return err
+}
+
+const searchChirps = `-- name: SearchChirps :many
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.lang, chirps.in_reply_to, chirps.root_id,
+    ts_rank(chirps.search_vector, websearch_to_tsquery('english', $1::text)) AS rank,
+    ts_headline(
+        'english',
+        replace(replace(replace(chirps.body, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'),
+        websearch_to_tsquery('english', $1::text),
+        'StartSel=<mark>, StopSel=</mark>, MaxWords=20, MinWords=8'
+    ) AS snippet
+FROM chirps
+JOIN users ON users.id = chirps.user_id
+WHERE chirps.search_vector @@ websearch_to_tsquery('english', $1::text)
+AND users.deactivated_at IS NULL
+AND chirps.deleted_at IS NULL
+AND ($2::uuid IS NULL OR chirps.user_id = $2)
+AND ($3::real IS NULL OR (ts_rank(chirps.search_vector, websearch_to_tsquery('english', $1::text)), chirps.id) < ($3, $4::uuid))
+ORDER BY rank DESC, chirps.id DESC
+LIMIT $5::int
+`
+
+type SearchChirpsRow struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Body      string
+	UserID    uuid.UUID
+	Lang      sql.NullString
+	InReplyTo uuid.NullUUID
+	RootID    uuid.NullUUID
+	Rank      float32
+	Snippet   string
+}
+
+type SearchChirpsParams struct {
+	Query      string
+	AuthorID   uuid.NullUUID
+	CursorRank sql.NullFloat64
+	CursorID   uuid.NullUUID
+	RowLimit   int32
+}
+
+func (q *Queries) SearchChirps(ctx context.Context, arg SearchChirpsParams) ([]SearchChirpsRow, error) {
+	rows, err := q.db.QueryContext(ctx, searchChirps,
+		arg.Query,
+		arg.AuthorID,
+		arg.CursorRank,
+		arg.CursorID,
+		arg.RowLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SearchChirpsRow
+	for rows.Next() {
+		var i SearchChirpsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.Lang,
+			&i.InReplyTo,
+			&i.RootID,
+			&i.Rank,
+			&i.Snippet,
 		); err != nil {
 			return nil, err
 		}
@@ -155,3 +584,60 @@ func (q *Queries) GetChirpsByAuthorID(ctx context.Context, userID uuid.UUID) ([]
 	}
 	return items, nil
 }
+
+const tombstoneChirp = `-- name: TombstoneChirp :execrows
+WITH revisions AS (
+    DELETE FROM chirp_revisions
+    WHERE chirp_id = $1
+    AND EXISTS (SELECT 1 FROM chirps replies WHERE replies.in_reply_to = $1)
+)
+UPDATE chirps
+SET body = '', lang = NULL, deleted_at = NOW()
+WHERE id = $1
+AND EXISTS (SELECT 1 FROM chirps replies WHERE replies.in_reply_to = chirps.id)
+`
+
+func (q *Queries) TombstoneChirp(ctx context.Context, chirpID uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, tombstoneChirp, chirpID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const updateChirpBody = `-- name: UpdateChirpBody :one
+WITH revision AS (
+    INSERT INTO chirp_revisions (id, chirp_id, body, created_at, replaced_at)
+    SELECT gen_random_uuid(), chirps.id, chirps.body, chirps.updated_at, NOW()
+    FROM chirps
+    WHERE chirps.id = $1
+    FOR UPDATE
+)
+UPDATE chirps
+SET body = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.search_vector, chirps.lang, chirps.in_reply_to, chirps.root_id, chirps.deleted_at
+`
+
+type UpdateChirpBodyParams struct {
+	ID   uuid.UUID
+	Body string
+}
+
+func (q *Queries) UpdateChirpBody(ctx context.Context, arg UpdateChirpBodyParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, updateChirpBody, arg.ID, arg.Body)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.SearchVector,
+		&i.Lang,
+		&i.InReplyTo,
+		&i.RootID,
+		&i.DeletedAt,
+	)
+	return i, err
+}
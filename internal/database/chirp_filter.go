@@ -0,0 +1,76 @@
+package database
+
+import (
+	"context"
+	"fmt"
+)
+
+// ListFilteredChirpsParams are the parameters of ListChirpsAfter and
+// ListChirpsBefore with an extra condition, such as one compiled from a
+// search query. The condition's placeholders start at $5 and FilterArgs
+// holds their values.
+type ListFilteredChirpsParams struct {
+	ListChirpsAfterParams
+	Filter     string
+	FilterArgs []any
+	// Descending pages through the chirps like ListChirpsBefore.
+	Descending bool
+}
+
+// ListFilteredChirps is written by hand because sqlc can't generate a query
+// whose WHERE clause is only known at run time. It returns the same rows as
+// ListChirpsAfter or ListChirpsBefore, narrowed by the filter.
+func (q *Queries) ListFilteredChirps(ctx context.Context, arg ListFilteredChirpsParams) ([]Chirp, error) {
+	cmp, order := ">", "ASC"
+	if arg.Descending {
+		cmp, order = "<", "DESC"
+	}
+	query := fmt.Sprintf(`SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.search_vector, chirps.lang, chirps.in_reply_to, chirps.root_id, chirps.deleted_at FROM chirps
+JOIN users ON users.id = chirps.user_id
+WHERE users.deactivated_at IS NULL
+AND chirps.deleted_at IS NULL
+AND ($1::uuid IS NULL OR chirps.user_id = $1)
+AND ($2::timestamp IS NULL OR (chirps.created_at, chirps.id) %[1]s ($2, $3::uuid))
+AND (%[2]s)
+ORDER BY chirps.created_at %[3]s, chirps.id %[3]s
+LIMIT $4::int
+`, cmp, arg.Filter, order)
+
+	args := append([]any{
+		arg.AuthorID,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.RowLimit,
+	}, arg.FilterArgs...)
+	rows, err := q.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.SearchVector,
+			&i.Lang,
+			&i.InReplyTo,
+			&i.RootID,
+			&i.DeletedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
@@ -11,28 +11,213 @@ import (
 	"github.com/google/uuid"
 )
 
-type Chirp struct {
+type AccessTokenWatermark struct {
+	UserID        uuid.UUID
+	RevokedBefore time.Time
+}
+
+type AuditLog struct {
 	ID        uuid.UUID
+	Action    string
+	ActorID   uuid.UUID
+	SubjectID uuid.UUID
+	Details   string
 	CreatedAt time.Time
-	UpdatedAt time.Time
-	Body      string
+}
+
+type Chirp struct {
+	ID           uuid.UUID
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Body         string
+	UserID       uuid.UUID
+	SearchVector interface{}
+	Lang         sql.NullString
+	InReplyTo    uuid.NullUUID
+	RootID       uuid.NullUUID
+	DeletedAt    sql.NullTime
+}
+
+type ChirpRevision struct {
+	ID         uuid.UUID
+	ChirpID    uuid.UUID
+	Body       string
+	CreatedAt  time.Time
+	ReplacedAt time.Time
+}
+
+type LoginAttempt struct {
+	Key           string
+	Failures      int32
+	LastFailureAt time.Time
+}
+
+type MagicLinkToken struct {
+	TokenHash string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    sql.NullTime
 	UserID    uuid.UUID
 }
 
-type RefreshToken struct {
-	Token     string
+type OauthAuthorizationCode struct {
+	CodeHash      string
+	CreatedAt     time.Time
+	ExpiresAt     time.Time
+	UsedAt        sql.NullTime
+	RedirectUri   string
+	Scopes        string
+	CodeChallenge string
+	ClientID      uuid.UUID
+	UserID        uuid.UUID
+}
+
+type OauthClient struct {
+	ID           uuid.UUID
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Name         string
+	SecretHash   sql.NullString
+	RedirectUris string
+	UserID       uuid.UUID
+}
+
+type OauthGrant struct {
+	UserID    uuid.UUID
+	ClientID  uuid.UUID
+	Scopes    string
 	CreatedAt time.Time
 	UpdatedAt time.Time
+}
+
+type OauthRefreshToken struct {
+	TokenHash string
+	CreatedAt time.Time
 	ExpiresAt time.Time
 	RevokedAt sql.NullTime
+	Scopes    string
+	ClientID  uuid.UUID
 	UserID    uuid.UUID
+	RotatedAt sql.NullTime
+}
+
+type OidcLoginState struct {
+	StateHash    string
+	Nonce        string
+	CodeVerifier string
+	CreatedAt    time.Time
+	ExpiresAt    time.Time
+}
+
+type PasswordResetToken struct {
+	TokenHash string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    sql.NullTime
+	UserID    uuid.UUID
+}
+
+type PersonalAccessToken struct {
+	ID         uuid.UUID
+	Name       string
+	TokenHash  string
+	Scopes     string
+	CreatedAt  time.Time
+	ExpiresAt  sql.NullTime
+	LastUsedAt sql.NullTime
+	RevokedAt  sql.NullTime
+	UserID     uuid.UUID
+}
+
+type RecoveryCode struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	CodeHash  string
+	UsedAt    sql.NullTime
+	UserID    uuid.UUID
+}
+
+type RefreshToken struct {
+	Token       string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	ExpiresAt   time.Time
+	RevokedAt   sql.NullTime
+	UserID      uuid.UUID
+	FamilyID    uuid.UUID
+	ReplacedBy  sql.NullString
+	UserAgent   string
+	IpAddress   string
+	DeviceLabel string
+}
+
+type RevokedAccessToken struct {
+	Jti       string
+	RevokedAt time.Time
+	ExpiresAt time.Time
+}
+
+type SecurityEvent struct {
+	ID        uuid.UUID
+	UserID    uuid.NullUUID
+	EventType string
+	IpAddress string
+	UserAgent string
+	Details   string
+	CreatedAt time.Time
+}
+
+type TotpCredential struct {
+	UserID       uuid.UUID
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Secret       string
+	ConfirmedAt  sql.NullTime
+	LastUsedStep sql.NullInt64
 }
 
 type User struct {
-	ID             uuid.UUID
-	CreatedAt      time.Time
-	UpdatedAt      time.Time
-	Email          string
-	HashedPassword string
-	IsChirpyRed    bool
+	ID              uuid.UUID
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	Email           string
+	HashedPassword  string
+	IsChirpyRed     bool
+	EmailVerifiedAt sql.NullTime
+	Role            string
+	DeactivatedAt   sql.NullTime
+}
+
+type UserIdentity struct {
+	ID          uuid.UUID
+	Issuer      string
+	Subject     string
+	Email       string
+	CreatedAt   time.Time
+	LastLoginAt time.Time
+	UserID      uuid.UUID
+}
+
+type WebauthnCredential struct {
+	ID              uuid.UUID
+	CredentialID    []byte
+	PublicKey       []byte
+	AttestationType string
+	Transports      string
+	Aaguid          []byte
+	SignCount       int64
+	BackupEligible  bool
+	BackupState     bool
+	Name            string
+	CreatedAt       time.Time
+	LastUsedAt      sql.NullTime
+	UserID          uuid.UUID
+}
+
+type WebauthnSession struct {
+	ID        uuid.UUID
+	Data      string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UserID    uuid.NullUUID
 }
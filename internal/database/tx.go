@@ -0,0 +1,26 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+)
+
+// InTx runs fn with queries that share one transaction. The transaction is
+// committed if fn returns nil and rolled back otherwise. Queries that are
+// already part of a transaction run fn in it.
+func (q *Queries) InTx(ctx context.Context, fn func(*Queries) error) error {
+	db, ok := q.db.(*sql.DB)
+	if !ok {
+		return fn(q)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(q.WithTx(tx)); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,110 @@
+// Package deletion purges accounts whose owners asked for them to be
+// deleted, once the grace period for changing their mind has passed.
+package deletion
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yujen77300/Chirpy-Server/internal/database"
+)
+
+// DefaultGracePeriod is how long a deactivated account can still be restored
+// by logging in.
+const DefaultGracePeriod = time.Hour * 24 * 30
+
+// DeletedAuthorID is the placeholder account that keeps the tombstones of
+// purged users' chirps that have replies. It can't log in.
+var DeletedAuthorID = uuid.MustParse("00000000-0000-0000-0000-000000000000")
+
+// Purger hard-deletes deactivated accounts. Deleting the user cascades to
+// their chirps, sessions and credentials; security events and audit log
+// entries are kept. Chirps that have replies are emptied and handed to
+// DeletedAuthorID first, so the conversations keep their shape.
+type Purger struct {
+	db          *database.Queries
+	gracePeriod time.Duration
+	now         func() time.Time
+}
+
+func NewPurger(db *database.Queries, gracePeriod time.Duration) *Purger {
+	return &Purger{
+		db:          db,
+		gracePeriod: gracePeriod,
+		now:         time.Now,
+	}
+}
+
+// Purge deletes every account deactivated more than the grace period ago
+// and returns how many were deleted.
+func (p *Purger) Purge(ctx context.Context) (int, error) {
+	before := p.now().UTC().Add(-p.gracePeriod)
+	var ids []uuid.UUID
+	err := p.db.InTx(ctx, func(q *database.Queries) error {
+		err := q.CreateDeletedAuthor(ctx, DeletedAuthorID)
+		if err != nil {
+			return err
+		}
+		_, err = q.HandOverRepliedChirps(ctx, database.HandOverRepliedChirpsParams{
+			DeactivatedBefore: before,
+			DeletedAuthorID:   DeletedAuthorID,
+		})
+		if err != nil {
+			return err
+		}
+		ids, err = q.PurgeDeactivatedUsers(ctx, before)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// The purged chirps may have been the last replies to tombstones, and
+	// removing those can empty the tombstones above them in turn.
+	for {
+		n, err := p.db.DeleteEmptyTombstones(ctx)
+		if err != nil {
+			log.Printf("Error deleting empty tombstones: %s", err)
+			break
+		}
+		if n == 0 {
+			break
+		}
+	}
+
+	for _, id := range ids {
+		err := p.db.CreateSecurityEvent(ctx, database.CreateSecurityEventParams{
+			UserID:    uuid.NullUUID{UUID: id, Valid: true},
+			EventType: "account.deleted",
+			Details:   "grace period ended",
+		})
+		if err != nil {
+			log.Printf("Error recording account.deleted security event: %s", err)
+		}
+	}
+	return len(ids), nil
+}
+
+// Run purges accounts every interval until ctx is done.
+func (p *Purger) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := p.Purge(ctx)
+			if err != nil {
+				log.Printf("Error purging deactivated accounts: %s", err)
+				continue
+			}
+			if n > 0 {
+				log.Printf("Purged %d deactivated accounts", n)
+			}
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package lockout
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Record is the failure history of one key, an account or a client IP.
+type Record struct {
+	Failures    int
+	LastFailure time.Time
+}
+
+// Store keeps failure records. The memory store is enough for a single
+// instance; the Postgres store shares lockouts between instances.
+type Store interface {
+	Get(ctx context.Context, key string) (Record, error)
+	// RecordFailure atomically adds a failure for key. Failures older than
+	// resetAfter are forgotten first.
+	RecordFailure(ctx context.Context, key string, now time.Time, resetAfter time.Duration) (Record, error)
+	Reset(ctx context.Context, key string) error
+}
+
+// Policy controls how quickly repeated failures lock a key out.
+type Policy struct {
+	// FreeAttempts is the number of failures allowed before any delay.
+	FreeAttempts int
+	// BaseDelay is the lockout after the first failure past FreeAttempts.
+	// It doubles with every further failure, up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// ResetAfter is how long a key must go without failures to be forgiven.
+	ResetAfter time.Duration
+}
+
+var (
+	DefaultAccountPolicy = Policy{
+		FreeAttempts: 5,
+		BaseDelay:    time.Second,
+		MaxDelay:     time.Minute * 15,
+		ResetAfter:   time.Hour * 24,
+	}
+	// DefaultIPPolicy is more lenient since many users can share an address.
+	DefaultIPPolicy = Policy{
+		FreeAttempts: 20,
+		BaseDelay:    time.Second,
+		MaxDelay:     time.Minute * 15,
+		ResetAfter:   time.Hour * 24,
+	}
+	// DefaultMagicLinkPolicy limits login link emails per address. Every
+	// request counts, not just failed ones.
+	DefaultMagicLinkPolicy = Policy{
+		FreeAttempts: 3,
+		BaseDelay:    time.Minute,
+		MaxDelay:     time.Hour,
+		ResetAfter:   time.Hour,
+	}
+	// DefaultMagicLinkIPPolicy limits login link emails per client IP.
+	DefaultMagicLinkIPPolicy = Policy{
+		FreeAttempts: 30,
+		BaseDelay:    time.Minute,
+		MaxDelay:     time.Hour,
+		ResetAfter:   time.Hour,
+	}
+	// DefaultPasskeyLoginIPPolicy limits passkey logins started per client
+	// IP. Every one is stored until it expires, and no account is known yet.
+	DefaultPasskeyLoginIPPolicy = Policy{
+		FreeAttempts: 60,
+		BaseDelay:    time.Second,
+		MaxDelay:     time.Minute * 15,
+		ResetAfter:   time.Hour,
+	}
+)
+
+// delay returns how long a key with the given number of failures is locked.
+func (p Policy) delay(failures int) time.Duration {
+	if failures <= p.FreeAttempts {
+		return 0
+	}
+	d := p.BaseDelay
+	for i := p.FreeAttempts + 1; i < failures; i++ {
+		d *= 2
+		if d >= p.MaxDelay {
+			return p.MaxDelay
+		}
+	}
+	return min(d, p.MaxDelay)
+}
+
+// Guard tracks failed logins per account and per client IP.
+type Guard struct {
+	store     Store
+	namespace string
+	account   Policy
+	ip        Policy
+	now       func() time.Time
+}
+
+func NewGuard(store Store, account, ip Policy) *Guard {
+	return NewNamespacedGuard(store, "", account, ip)
+}
+
+// NewNamespacedGuard returns a guard whose records are kept under namespace,
+// apart from those of other guards sharing the store. Rate limits that count
+// something other than failed logins use one, so they can't lock anyone out
+// of logging in.
+func NewNamespacedGuard(store Store, namespace string, account, ip Policy) *Guard {
+	return &Guard{
+		store:     store,
+		namespace: namespace,
+		account:   account,
+		ip:        ip,
+		now:       time.Now,
+	}
+}
+
+// AccountKey is the key for an email address, whether or not an account with
+// that address exists.
+func AccountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func IPKey(ip string) string {
+	return "ip:" + ip
+}
+
+func (g *Guard) policy(key string) Policy {
+	if strings.HasPrefix(key, "ip:") {
+		return g.ip
+	}
+	return g.account
+}
+
+// Check returns how long the caller has to wait before trying again, or zero
+// if none of the keys is locked.
+func (g *Guard) Check(ctx context.Context, keys ...string) (time.Duration, error) {
+	now := g.now()
+	var wait time.Duration
+	for _, key := range keys {
+		record, err := g.store.Get(ctx, g.namespace+key)
+		if err != nil {
+			return 0, err
+		}
+		wait = max(wait, g.retryAfter(key, record, now))
+	}
+	return wait, nil
+}
+
+// RecordFailure counts a failed attempt against every key and returns how
+// long the caller now has to wait.
+func (g *Guard) RecordFailure(ctx context.Context, keys ...string) (time.Duration, error) {
+	now := g.now()
+	var wait time.Duration
+	for _, key := range keys {
+		record, err := g.store.RecordFailure(ctx, g.namespace+key, now, g.policy(key).ResetAfter)
+		if err != nil {
+			return 0, err
+		}
+		wait = max(wait, g.retryAfter(key, record, now))
+	}
+	return wait, nil
+}
+
+// Reset forgets all failures of a key, after a successful login or when an
+// admin clears a lockout.
+func (g *Guard) Reset(ctx context.Context, key string) error {
+	return g.store.Reset(ctx, g.namespace+key)
+}
+
+func (g *Guard) retryAfter(key string, record Record, now time.Time) time.Duration {
+	policy := g.policy(key)
+	if record.Failures == 0 || now.Sub(record.LastFailure) > policy.ResetAfter {
+		return 0
+	}
+	wait := record.LastFailure.Add(policy.delay(record.Failures)).Sub(now)
+	if wait <= 0 {
+		return 0
+	}
+	// Round up so clients never retry a moment too early.
+	return (wait + time.Second - 1).Truncate(time.Second)
+}
@@ -0,0 +1,87 @@
+package lockout
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestGuard(t *testing.T) {
+	policy := Policy{
+		FreeAttempts: 2,
+		BaseDelay:    time.Second,
+		MaxDelay:     time.Second * 4,
+		ResetAfter:   time.Hour,
+	}
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	guard := NewGuard(NewMemoryStore(), policy, policy)
+	guard.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	account := AccountKey("User@Example.com ")
+
+	tests := []struct {
+		name     string
+		wantWait time.Duration
+	}{
+		{name: "First failure is free", wantWait: 0},
+		{name: "Second failure is free", wantWait: 0},
+		{name: "Third failure locks for the base delay", wantWait: time.Second},
+		{name: "Fourth failure doubles the delay", wantWait: time.Second * 2},
+		{name: "Fifth failure doubles again", wantWait: time.Second * 4},
+		{name: "Delay is capped", wantWait: time.Second * 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotWait, err := guard.RecordFailure(ctx, account)
+			if err != nil {
+				t.Fatalf("RecordFailure() error = %v", err)
+			}
+			if gotWait != tt.wantWait {
+				t.Errorf("RecordFailure() wait = %v, want %v", gotWait, tt.wantWait)
+			}
+		})
+	}
+
+	if wait, _ := guard.Check(ctx, AccountKey("user@example.com")); wait != time.Second*4 {
+		t.Errorf("Check() wait = %v, want %v", wait, time.Second*4)
+	}
+
+	now = now.Add(time.Second * 5)
+	if wait, _ := guard.Check(ctx, account); wait != 0 {
+		t.Errorf("Check() after the lockout wait = %v, want 0", wait)
+	}
+
+	guard.Reset(ctx, account)
+	if wait, _ := guard.RecordFailure(ctx, account); wait != 0 {
+		t.Errorf("RecordFailure() after Reset() wait = %v, want 0", wait)
+	}
+}
+
+func TestNamespacedGuard(t *testing.T) {
+	policy := Policy{
+		FreeAttempts: 1,
+		BaseDelay:    time.Second,
+		MaxDelay:     time.Second,
+		ResetAfter:   time.Hour,
+	}
+	store := NewMemoryStore()
+	login := NewGuard(store, policy, policy)
+	links := NewNamespacedGuard(store, "magic-link:", policy, policy)
+
+	ctx := context.Background()
+	ip := IPKey("203.0.113.7")
+	for range 3 {
+		if _, err := links.RecordFailure(ctx, ip); err != nil {
+			t.Fatalf("RecordFailure() error = %v", err)
+		}
+	}
+
+	if wait, _ := links.Check(ctx, ip); wait == 0 {
+		t.Errorf("Check() on the namespaced guard wait = 0, want a lockout")
+	}
+	if wait, _ := login.Check(ctx, ip); wait != 0 {
+		t.Errorf("Check() on the other guard wait = %v, want 0", wait)
+	}
+}
@@ -0,0 +1,59 @@
+package lockout
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepEvery is how many writes pass between removals of expired records.
+const sweepEvery = 1000
+
+// MemoryStore keeps failure records in process memory.
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[string]Record
+	writes  int
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		records: map[string]Record{},
+	}
+}
+
+func (s *MemoryStore) Get(ctx context.Context, key string) (Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.records[key], nil
+}
+
+func (s *MemoryStore) RecordFailure(ctx context.Context, key string, now time.Time, resetAfter time.Duration) (Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.writes++
+	if s.writes%sweepEvery == 0 {
+		for k, r := range s.records {
+			if now.Sub(r.LastFailure) > resetAfter {
+				delete(s.records, k)
+			}
+		}
+	}
+
+	record := s.records[key]
+	if now.Sub(record.LastFailure) > resetAfter {
+		record = Record{}
+	}
+	record.Failures++
+	record.LastFailure = now
+	s.records[key] = record
+	return record, nil
+}
+
+func (s *MemoryStore) Reset(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, key)
+	return nil
+}
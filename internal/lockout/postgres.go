@@ -0,0 +1,55 @@
+package lockout
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/yujen77300/Chirpy-Server/internal/database"
+)
+
+// PostgresStore keeps failure records in the login_attempts table so every
+// instance of the server sees the same lockouts.
+type PostgresStore struct {
+	db *database.Queries
+}
+
+func NewPostgresStore(db *database.Queries) *PostgresStore {
+	return &PostgresStore{
+		db: db,
+	}
+}
+
+func (s *PostgresStore) Get(ctx context.Context, key string) (Record, error) {
+	attempt, err := s.db.GetLoginAttempt(ctx, key)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Record{}, nil
+		}
+		return Record{}, err
+	}
+	return Record{
+		Failures:    int(attempt.Failures),
+		LastFailure: attempt.LastFailureAt,
+	}, nil
+}
+
+func (s *PostgresStore) RecordFailure(ctx context.Context, key string, now time.Time, resetAfter time.Duration) (Record, error) {
+	attempt, err := s.db.RecordLoginFailure(ctx, database.RecordLoginFailureParams{
+		Key:         key,
+		Now:         now.UTC(),
+		ResetBefore: now.UTC().Add(-resetAfter),
+	})
+	if err != nil {
+		return Record{}, err
+	}
+	return Record{
+		Failures:    int(attempt.Failures),
+		LastFailure: attempt.LastFailureAt,
+	}, nil
+}
+
+func (s *PostgresStore) Reset(ctx context.Context, key string) error {
+	return s.db.DeleteLoginAttempt(ctx, key)
+}
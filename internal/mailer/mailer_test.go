@@ -0,0 +1,35 @@
+package mailer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileMailer(t *testing.T) {
+	dir := t.TempDir()
+	m := NewFileMailer(dir, "chirpy@example.com")
+
+	err := m.Send(context.Background(), Message{
+		To:      "user@example.com",
+		Subject: "Reset your password",
+		Body:    "https://chirpy.example.com/reset?token=abc",
+	})
+	if err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("Send() wrote %d files, want 1", len(files))
+	}
+
+	data, _ := os.ReadFile(files[0])
+	for _, want := range []string{"To: user@example.com", "Subject: Reset your password", "token=abc"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("Send() message missing %q", want)
+		}
+	}
+}
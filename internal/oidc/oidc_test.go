@@ -0,0 +1,370 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testClientID     = "chirpy"
+	testClientSecret = "s3cr=t&"
+	testRedirectURL  = "http://localhost:8080/api/login/oidc/callback"
+)
+
+// mockIdP is a minimal OpenID Connect provider. Its /authorize endpoint logs
+// in the configured user without asking and redirects back with a code.
+type mockIdP struct {
+	server *httptest.Server
+
+	mu            sync.Mutex
+	kid           string
+	key           *rsa.PrivateKey
+	subject       string
+	email         string
+	emailVerified any
+	codes         map[string]url.Values
+	jwksRequests  int
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	t.Helper()
+	idp := &mockIdP{
+		subject:       "user-123",
+		email:         "user@example.com",
+		emailVerified: true,
+		codes:         map[string]url.Values{},
+	}
+	idp.rotateKey(t, "key-1")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", idp.discovery)
+	mux.HandleFunc("GET /jwks", idp.jwks)
+	mux.HandleFunc("GET /authorize", idp.authorize)
+	mux.HandleFunc("POST /token", idp.token)
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+func (idp *mockIdP) rotateKey(t *testing.T, kid string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey() error = %v", err)
+	}
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	idp.kid = kid
+	idp.key = key
+}
+
+func (idp *mockIdP) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]any{
+		"issuer":                           idp.server.URL,
+		"authorization_endpoint":           idp.server.URL + "/authorize?prompt=none",
+		"token_endpoint":                   idp.server.URL + "/token",
+		"jwks_uri":                         idp.server.URL + "/jwks",
+		"code_challenge_methods_supported": []string{"S256"},
+	})
+}
+
+func (idp *mockIdP) jwks(w http.ResponseWriter, r *http.Request) {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	idp.jwksRequests++
+	json.NewEncoder(w).Encode(map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": idp.kid,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(idp.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(idp.key.E)).Bytes()),
+		}},
+	})
+}
+
+func (idp *mockIdP) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != testClientID || query.Get("code_challenge_method") != "S256" || query.Get("prompt") != "none" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	code := rand.Text()
+	idp.mu.Lock()
+	idp.codes[code] = query
+	idp.mu.Unlock()
+
+	redirect, _ := url.Parse(query.Get("redirect_uri"))
+	redirect.RawQuery = url.Values{"code": {code}, "state": {query.Get("state")}}.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (idp *mockIdP) token(w http.ResponseWriter, r *http.Request) {
+	fail := func(code string) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": code})
+	}
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		fail("invalid_client")
+		return
+	}
+	clientID, _ = url.QueryUnescape(clientID)
+	clientSecret, _ = url.QueryUnescape(clientSecret)
+	if clientID != testClientID || clientSecret != testClientSecret {
+		fail("invalid_client")
+		return
+	}
+
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	authRequest, ok := idp.codes[r.FormValue("code")]
+	delete(idp.codes, r.FormValue("code"))
+	if !ok || r.FormValue("grant_type") != "authorization_code" || r.FormValue("redirect_uri") != authRequest.Get("redirect_uri") {
+		fail("invalid_grant")
+		return
+	}
+	sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != authRequest.Get("code_challenge") {
+		fail("invalid_grant")
+		return
+	}
+
+	idToken, err := idp.signIDToken(jwt.MapClaims{
+		"nonce": authRequest.Get("nonce"),
+	})
+	if err != nil {
+		fail("server_error")
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{
+		"access_token": "unused",
+		"token_type":   "Bearer",
+		"id_token":     idToken,
+	})
+}
+
+// signIDToken must be called with mu held. extra claims override the defaults.
+func (idp *mockIdP) signIDToken(extra jwt.MapClaims) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":            idp.server.URL,
+		"sub":            idp.subject,
+		"aud":            testClientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Minute * 5).Unix(),
+		"email":          idp.email,
+		"email_verified": idp.emailVerified,
+	}
+	for k, v := range extra {
+		claims[k] = v
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = idp.kid
+	return token.SignedString(idp.key)
+}
+
+func newTestProvider(idp *mockIdP) *Provider {
+	return NewProvider(Config{
+		Issuer:       idp.server.URL,
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+		RedirectURL:  testRedirectURL,
+	})
+}
+
+// login runs the browser's part of the flow and returns the code the IdP
+// redirected back with.
+func login(t *testing.T, p *Provider, nonce, verifier string) string {
+	t.Helper()
+	authURL, err := p.AuthCodeURL(context.Background(), "state-1", nonce, verifier)
+	if err != nil {
+		t.Fatalf("AuthCodeURL() error = %v", err)
+	}
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatalf("GET %s error = %v", authURL, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("GET %s status = %d, want %d", authURL, resp.StatusCode, http.StatusFound)
+	}
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("invalid redirect: %v", err)
+	}
+	if !strings.HasPrefix(location.String(), testRedirectURL) || location.Query().Get("state") != "state-1" {
+		t.Fatalf("redirect = %s", location)
+	}
+	return location.Query().Get("code")
+}
+
+func TestProviderLogin(t *testing.T) {
+	ctx := context.Background()
+	idp := newMockIdP(t)
+	p := newTestProvider(idp)
+
+	tests := []struct {
+		name          string
+		emailVerified any
+		exchangeNonce string
+		badVerifier   bool
+		wantVerified  bool
+		wantErr       bool
+	}{
+		{
+			name:          "Verified email",
+			emailVerified: true,
+			exchangeNonce: "nonce-1",
+			wantVerified:  true,
+		},
+		{
+			name:          "Verified email as a string",
+			emailVerified: "true",
+			exchangeNonce: "nonce-1",
+			wantVerified:  true,
+		},
+		{
+			name:          "Unverified email",
+			emailVerified: false,
+			exchangeNonce: "nonce-1",
+			wantVerified:  false,
+		},
+		{
+			name:          "Nonce mismatch",
+			emailVerified: true,
+			exchangeNonce: "nonce-2",
+			wantErr:       true,
+		},
+		{
+			name:          "Wrong code verifier",
+			emailVerified: true,
+			exchangeNonce: "nonce-1",
+			badVerifier:   true,
+			wantErr:       true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idp.mu.Lock()
+			idp.emailVerified = tt.emailVerified
+			idp.mu.Unlock()
+
+			verifier, err := NewPKCEVerifier()
+			if err != nil {
+				t.Fatalf("NewPKCEVerifier() error = %v", err)
+			}
+			code := login(t, p, "nonce-1", verifier)
+			if tt.badVerifier {
+				verifier, _ = NewPKCEVerifier()
+			}
+
+			idToken, err := p.Exchange(ctx, code, verifier, tt.exchangeNonce)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Exchange() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if idToken.Subject != "user-123" || idToken.Email != "user@example.com" || idToken.Issuer != idp.server.URL {
+				t.Errorf("Exchange() = %+v", idToken)
+			}
+			if idToken.EmailVerified != tt.wantVerified {
+				t.Errorf("Exchange() EmailVerified = %v, want %v", idToken.EmailVerified, tt.wantVerified)
+			}
+		})
+	}
+}
+
+func TestProviderVerify(t *testing.T) {
+	ctx := context.Background()
+	idp := newMockIdP(t)
+	p := newTestProvider(idp)
+
+	sign := func(extra jwt.MapClaims) string {
+		t.Helper()
+		idp.mu.Lock()
+		defer idp.mu.Unlock()
+		token, err := idp.signIDToken(extra)
+		if err != nil {
+			t.Fatalf("signIDToken() error = %v", err)
+		}
+		return token
+	}
+
+	if _, err := p.Verify(ctx, sign(jwt.MapClaims{"nonce": "n"}), "n"); err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		extra jwt.MapClaims
+	}{
+		{name: "Wrong audience", extra: jwt.MapClaims{"nonce": "n", "aud": "someone-else"}},
+		{name: "Wrong issuer", extra: jwt.MapClaims{"nonce": "n", "iss": "https://evil.example.com"}},
+		{name: "Expired", extra: jwt.MapClaims{"nonce": "n", "exp": time.Now().Add(-time.Hour).Unix()}},
+		{name: "Missing nonce", extra: jwt.MapClaims{}},
+		{name: "Another party", extra: jwt.MapClaims{"nonce": "n", "aud": []string{testClientID, "other"}, "azp": "other"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := p.Verify(ctx, sign(tt.extra), "n"); err == nil {
+				t.Error("Verify() error = nil, want an error")
+			}
+		})
+	}
+
+	t.Run("Unsigned token", func(t *testing.T) {
+		token := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{
+			"iss": idp.server.URL, "sub": "user-123", "aud": testClientID, "nonce": "n",
+			"iat": time.Now().Unix(), "exp": time.Now().Add(time.Minute).Unix(),
+		})
+		raw, _ := token.SignedString(jwt.UnsafeAllowNoneSignatureType)
+		if _, err := p.Verify(ctx, raw, "n"); err == nil {
+			t.Error("Verify() accepted an unsigned token")
+		}
+	})
+
+	t.Run("Key rotation", func(t *testing.T) {
+		p.now = func() time.Time { return time.Now().Add(minRefetchInterval) }
+		idp.rotateKey(t, "key-2")
+		if _, err := p.Verify(ctx, sign(jwt.MapClaims{"nonce": "n"}), "n"); err != nil {
+			t.Errorf("Verify() after key rotation error = %v", err)
+		}
+
+		// Unknown keys don't trigger another fetch right away.
+		requests := idp.jwksRequests
+		idp.rotateKey(t, "key-3")
+		if _, err := p.Verify(ctx, sign(jwt.MapClaims{"nonce": "n"}), "n"); err == nil {
+			t.Error("Verify() with an unknown key error = nil")
+		}
+		if idp.jwksRequests != requests {
+			t.Errorf("JWKS fetched %d more times, want 0", idp.jwksRequests-requests)
+		}
+	})
+}
@@ -0,0 +1,62 @@
+package revocation
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yujen77300/Chirpy-Server/internal/database"
+)
+
+// PostgresStore keeps revocations in the revoked_access_tokens and
+// access_token_watermarks tables.
+type PostgresStore struct {
+	db *database.Queries
+}
+
+func NewPostgresStore(db *database.Queries) *PostgresStore {
+	return &PostgresStore{
+		db: db,
+	}
+}
+
+func (s *PostgresStore) RevokeToken(ctx context.Context, tokenID string, expiresAt time.Time) error {
+	return s.db.RevokeAccessToken(ctx, database.RevokeAccessTokenParams{
+		Jti:       tokenID,
+		ExpiresAt: expiresAt,
+	})
+}
+
+func (s *PostgresStore) RevokeUserTokens(ctx context.Context, userID uuid.UUID, before time.Time) error {
+	return s.db.SetAccessTokenWatermark(ctx, database.SetAccessTokenWatermarkParams{
+		UserID:        userID,
+		RevokedBefore: before,
+	})
+}
+
+func (s *PostgresStore) Load(ctx context.Context, now, since time.Time) (State, error) {
+	tokens, err := s.db.GetRevokedAccessTokens(ctx, now)
+	if err != nil {
+		return State{}, err
+	}
+	watermarks, err := s.db.GetAccessTokenWatermarks(ctx, since)
+	if err != nil {
+		return State{}, err
+	}
+
+	state := State{
+		Tokens: make(map[string]time.Time, len(tokens)),
+		Users:  make(map[uuid.UUID]time.Time, len(watermarks)),
+	}
+	for _, token := range tokens {
+		state.Tokens[token.Jti] = token.ExpiresAt
+	}
+	for _, watermark := range watermarks {
+		state.Users[watermark.UserID] = watermark.RevokedBefore
+	}
+	return state, nil
+}
+
+func (s *PostgresStore) DeleteExpired(ctx context.Context, now time.Time) error {
+	return s.db.DeleteExpiredRevokedAccessTokens(ctx, now)
+}
@@ -0,0 +1,171 @@
+package revocation
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// State is every revocation that can still affect a token.
+type State struct {
+	// Tokens maps the ID of each revoked token to its expiry.
+	Tokens map[string]time.Time
+	// Users maps a user to the time before which their tokens are revoked.
+	Users map[uuid.UUID]time.Time
+}
+
+// Store persists revocations so they survive restarts and are shared between
+// instances.
+type Store interface {
+	RevokeToken(ctx context.Context, tokenID string, expiresAt time.Time) error
+	RevokeUserTokens(ctx context.Context, userID uuid.UUID, before time.Time) error
+	// Load returns the revoked tokens that expire after now and the user
+	// watermarks later than since.
+	Load(ctx context.Context, now, since time.Time) (State, error)
+	DeleteExpired(ctx context.Context, now time.Time) error
+}
+
+// List is an in-memory copy of the revocations in a Store. Checks never touch
+// the store: revocations made through the List apply at once, those made by
+// other instances once the List next refreshes.
+type List struct {
+	store Store
+	// maxTokenAge is the longest lifetime of an access token. Watermarks
+	// older than that can't affect any token that is still valid.
+	maxTokenAge time.Duration
+	now         func() time.Time
+
+	mu     sync.RWMutex
+	tokens map[string]time.Time
+	users  map[uuid.UUID]time.Time
+}
+
+func NewList(store Store, maxTokenAge time.Duration) *List {
+	return &List{
+		store:       store,
+		maxTokenAge: maxTokenAge,
+		now:         time.Now,
+		tokens:      map[string]time.Time{},
+		users:       map[uuid.UUID]time.Time{},
+	}
+}
+
+// RevokeToken revokes a single token until it expires.
+func (l *List) RevokeToken(ctx context.Context, tokenID string, expiresAt time.Time) error {
+	if tokenID == "" {
+		return nil
+	}
+	err := l.store.RevokeToken(ctx, tokenID, expiresAt.UTC())
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens[tokenID] = expiresAt.UTC()
+	return nil
+}
+
+// RevokeUserTokens revokes every token issued to the user up to now. Token
+// issue times only have second precision, so the watermark is rounded up to
+// the next second: a token issued earlier in the same second must not
+// survive, even though one issued later in it is revoked too.
+func (l *List) RevokeUserTokens(ctx context.Context, userID uuid.UUID) error {
+	before := l.now().UTC().Truncate(time.Second).Add(time.Second)
+	err := l.store.RevokeUserTokens(ctx, userID, before)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if before.After(l.users[userID]) {
+		l.users[userID] = before
+	}
+	return nil
+}
+
+// ValidFrom returns the earliest issue time at which a new token for the
+// user isn't already revoked: now, or the user's watermark if that is later.
+func (l *List) ValidFrom(userID uuid.UUID) time.Time {
+	now := l.now().UTC()
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if before := l.users[userID]; before.After(now) {
+		return before
+	}
+	return now
+}
+
+// IsRevoked implements auth.RevocationChecker.
+func (l *List) IsRevoked(tokenID string, userID uuid.UUID, issuedAt time.Time) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if tokenID != "" {
+		if _, ok := l.tokens[tokenID]; ok {
+			return true
+		}
+	}
+	before, ok := l.users[userID]
+	return ok && issuedAt.Before(before)
+}
+
+// Refresh loads revocations made by other instances and forgets those that
+// no longer matter. Revocations are never undone, so the loaded state is
+// merged into the list rather than replacing it.
+func (l *List) Refresh(ctx context.Context) error {
+	now := l.now().UTC()
+	since := now.Add(-l.maxTokenAge)
+	state, err := l.store.Load(ctx, now, since)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for tokenID, expiresAt := range state.Tokens {
+		l.tokens[tokenID] = expiresAt
+	}
+	for userID, before := range state.Users {
+		if before.After(l.users[userID]) {
+			l.users[userID] = before
+		}
+	}
+	for tokenID, expiresAt := range l.tokens {
+		if !expiresAt.After(now) {
+			delete(l.tokens, tokenID)
+		}
+	}
+	for userID, before := range l.users {
+		if !before.After(since) {
+			delete(l.users, userID)
+		}
+	}
+	return nil
+}
+
+// Run refreshes the list every interval and removes expired revocations from
+// the store, until ctx is canceled.
+func (l *List) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := l.Refresh(ctx); err != nil {
+				log.Printf("Error refreshing token revocations: %s", err)
+			}
+			if err := l.store.DeleteExpired(ctx, l.now().UTC()); err != nil {
+				log.Printf("Error deleting expired token revocations: %s", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package revocation
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yujen77300/Chirpy-Server/internal/auth"
+)
+
+// memoryStore stands in for Postgres, which is shared by every List.
+type memoryStore struct {
+	state State
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		state: State{
+			Tokens: map[string]time.Time{},
+			Users:  map[uuid.UUID]time.Time{},
+		},
+	}
+}
+
+func (s *memoryStore) RevokeToken(ctx context.Context, tokenID string, expiresAt time.Time) error {
+	s.state.Tokens[tokenID] = expiresAt
+	return nil
+}
+
+func (s *memoryStore) RevokeUserTokens(ctx context.Context, userID uuid.UUID, before time.Time) error {
+	s.state.Users[userID] = before
+	return nil
+}
+
+func (s *memoryStore) Load(ctx context.Context, now, since time.Time) (State, error) {
+	return s.state, nil
+}
+
+func (s *memoryStore) DeleteExpired(ctx context.Context, now time.Time) error {
+	return nil
+}
+
+func TestList(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStore()
+	list := NewList(store, time.Hour)
+	// other plays a second instance of the server sharing the same store.
+	other := NewList(store, time.Hour)
+
+	keys := auth.NewHMACKeySet("secret")
+	keys.SetRevocationChecker(list)
+
+	userID := uuid.New()
+	otherUserID := uuid.New()
+	makeToken := func(userID uuid.UUID) (string, *auth.AccessClaims) {
+		t.Helper()
+		token, err := auth.MakeJWT(userID, keys, time.Hour)
+		if err != nil {
+			t.Fatalf("MakeJWT() error = %v", err)
+		}
+		claims, err := auth.ParseAccessToken(token, keys)
+		if err != nil {
+			t.Fatalf("ParseAccessToken() error = %v", err)
+		}
+		if claims.ID == "" {
+			t.Fatal("MakeJWT() issued a token without an ID")
+		}
+		return token, claims
+	}
+
+	revokedToken, revokedClaims := makeToken(userID)
+	keptToken, _ := makeToken(userID)
+
+	err := list.RevokeToken(ctx, revokedClaims.ID, revokedClaims.ExpiresAt.Time)
+	if err != nil {
+		t.Fatalf("RevokeToken() error = %v", err)
+	}
+	if _, _, err := auth.ValidateJWT(revokedToken, keys); !errors.Is(err, auth.ErrTokenRevoked) {
+		t.Errorf("ValidateJWT() of a revoked token error = %v, want %v", err, auth.ErrTokenRevoked)
+	}
+	if _, _, err := auth.ValidateJWT(keptToken, keys); err != nil {
+		t.Errorf("ValidateJWT() of another token error = %v", err)
+	}
+
+	if other.IsRevoked(revokedClaims.ID, userID, time.Now()) {
+		t.Error("IsRevoked() on another instance before Refresh() = true")
+	}
+	if err := other.Refresh(ctx); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	if !other.IsRevoked(revokedClaims.ID, userID, time.Now()) {
+		t.Error("IsRevoked() on another instance after Refresh() = false")
+	}
+
+	// Tokens issued in the same second as the revocation are revoked too.
+	otherUserToken, _ := makeToken(otherUserID)
+	err = list.RevokeUserTokens(ctx, userID)
+	if err != nil {
+		t.Fatalf("RevokeUserTokens() error = %v", err)
+	}
+	if _, _, err := auth.ValidateJWT(keptToken, keys); !errors.Is(err, auth.ErrTokenRevoked) {
+		t.Errorf("ValidateJWT() after RevokeUserTokens() error = %v, want %v", err, auth.ErrTokenRevoked)
+	}
+	if _, _, err := auth.ValidateJWT(otherUserToken, keys); err != nil {
+		t.Errorf("ValidateJWT() of another user's token error = %v", err)
+	}
+	if list.IsRevoked("", userID, time.Now().Add(time.Second*2)) {
+		t.Error("IsRevoked() of a token issued after RevokeUserTokens() = true")
+	}
+	newToken, err := auth.MakeJWT(userID, keys, time.Hour, auth.WithIssuedAt(list.ValidFrom(userID)))
+	if err != nil {
+		t.Fatalf("MakeJWT() error = %v", err)
+	}
+	if _, _, err := auth.ValidateJWT(newToken, keys); err != nil {
+		t.Errorf("ValidateJWT() of a token issued at ValidFrom() error = %v", err)
+	}
+
+	// Once expired, revocations are forgotten.
+	list.now = func() time.Time { return time.Now().Add(time.Hour * 2) }
+	store.state = State{}
+	if err := list.Refresh(ctx); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	if list.IsRevoked(revokedClaims.ID, userID, time.Time{}) {
+		t.Error("IsRevoked() after expiry = true")
+	}
+}
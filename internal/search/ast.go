@@ -0,0 +1,95 @@
+// Package search parses the chirp search language and compiles it to SQL.
+//
+// A query is a list of terms that must all match. Terms are words,
+// "exact phrases" and operators:
+//
+//	from:<user ID>            chirps by one user
+//	since:<YYYY-MM-DD>        chirps posted on or after the day (UTC)
+//	until:<YYYY-MM-DD>        chirps posted before the day (UTC)
+//	has:links                 chirps containing a link
+//	lang:<code>               chirps in a language, as an ISO 639-1 code
+//
+// A leading - excludes a term, OR between terms matches either of them and
+// binds tighter than the implicit AND, and parentheses group terms.
+package search
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Node is a node of a parsed query.
+type Node interface {
+	String() string
+}
+
+// Word matches chirps containing the word, or a word with the same stem.
+type Word struct {
+	Text string
+}
+
+// Phrase matches chirps containing the text exactly, ignoring case.
+type Phrase struct {
+	Text string
+}
+
+// From matches chirps by one user. Users are only given by ID, so a search
+// can't be used to find out whose email address is whose.
+type From struct {
+	UserID uuid.UUID
+}
+
+// Since matches chirps posted at or after Time.
+type Since struct {
+	Time time.Time
+}
+
+// Until matches chirps posted before Time.
+type Until struct {
+	Time time.Time
+}
+
+// HasLinks matches chirps containing a link.
+type HasLinks struct{}
+
+// Lang matches chirps written in a language.
+type Lang struct {
+	Code string
+}
+
+// Not matches chirps that Node doesn't.
+type Not struct {
+	Node Node
+}
+
+// And matches chirps that all of Nodes match.
+type And struct {
+	Nodes []Node
+}
+
+// Or matches chirps that any of Nodes match.
+type Or struct {
+	Nodes []Node
+}
+
+func (n Word) String() string   { return fmt.Sprintf("%q", n.Text) }
+func (n Phrase) String() string { return fmt.Sprintf("phrase(%q)", n.Text) }
+func (n Since) String() string  { return "since(" + n.Time.Format(time.DateOnly) + ")" }
+func (n Until) String() string  { return "until(" + n.Time.Format(time.DateOnly) + ")" }
+func (HasLinks) String() string { return "has(links)" }
+func (n Lang) String() string   { return "lang(" + n.Code + ")" }
+func (n Not) String() string    { return "not(" + n.Node.String() + ")" }
+func (n And) String() string    { return "and(" + join(n.Nodes) + ")" }
+func (n Or) String() string     { return "or(" + join(n.Nodes) + ")" }
+func (n From) String() string   { return "from(" + n.UserID.String() + ")" }
+
+func join(nodes []Node) string {
+	s := make([]string, len(nodes))
+	for i, n := range nodes {
+		s[i] = n.String()
+	}
+	return strings.Join(s, " ")
+}
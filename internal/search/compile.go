@@ -0,0 +1,72 @@
+package search
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Compile turns a parsed query into a SQL condition over the chirps table,
+// with the values passed as arguments rather than spliced in.
+// Placeholders are numbered from first, so the condition can follow a
+// statement's own parameters; the arguments are returned in that order.
+func Compile(n Node, first int) (string, []any) {
+	c := &compiler{next: first}
+	return c.compile(n), c.args
+}
+
+type compiler struct {
+	next int
+	args []any
+}
+
+// arg adds a value and returns its placeholder.
+func (c *compiler) arg(v any) string {
+	c.args = append(c.args, v)
+	c.next++
+	return fmt.Sprintf("$%d", c.next-1)
+}
+
+func (c *compiler) compile(n Node) string {
+	switch n := n.(type) {
+	case Word:
+		return "chirps.search_vector @@ plainto_tsquery('english', " + c.arg(n.Text) + ")"
+	case Phrase:
+		// The text search finds the candidates with the index; the LIKE
+		// makes sure the words are really next to each other as written,
+		// since stemming and stop words loosen phraseto_tsquery.
+		return "(chirps.search_vector @@ phraseto_tsquery('english', " + c.arg(n.Text) + ")" +
+			" AND chirps.body ILIKE " + c.arg("%"+escapeLike(n.Text)+"%") + ")"
+	case From:
+		return "chirps.user_id = " + c.arg(n.UserID)
+	case Since:
+		return "chirps.created_at >= " + c.arg(n.Time)
+	case Until:
+		return "chirps.created_at < " + c.arg(n.Time)
+	case HasLinks:
+		return "chirps.body ~* 'https?://'"
+	case Lang:
+		return "chirps.lang = " + c.arg(n.Code)
+	case Not:
+		return "NOT (" + c.compile(n.Node) + ")"
+	case And:
+		return c.join(n.Nodes, " AND ")
+	case Or:
+		return c.join(n.Nodes, " OR ")
+	default:
+		panic(fmt.Sprintf("search: unknown node %T", n))
+	}
+}
+
+func (c *compiler) join(nodes []Node, op string) string {
+	conds := make([]string, len(nodes))
+	for i, n := range nodes {
+		conds[i] = c.compile(n)
+	}
+	return "(" + strings.Join(conds, op) + ")"
+}
+
+// escapeLike makes s match literally in a LIKE pattern, using the default
+// escape character.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
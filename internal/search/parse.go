@@ -0,0 +1,349 @@
+package search
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// MaxTerms is the most words, phrases and operators a query may have.
+const MaxTerms = 20
+
+// Error is a mistake in a query. Pos is the 1-based column, in characters,
+// of the token it is about.
+type Error struct {
+	Pos   int
+	Token string
+	Msg   string
+}
+
+func (e *Error) Error() string {
+	if e.Token == "" {
+		return fmt.Sprintf("column %d: %s", e.Pos, e.Msg)
+	}
+	return fmt.Sprintf("column %d: %s: %q", e.Pos, e.Msg, e.Token)
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenWord
+	tokenPhrase
+	tokenMinus
+	tokenOr
+	tokenLParen
+	tokenRParen
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// lex splits a query into tokens. Words run until a space, a quote or a
+// parenthesis; a - only excludes when it starts a term.
+func lex(q string) ([]token, error) {
+	var tokens []token
+	runes := []rune(q)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		pos := i + 1
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{kind: tokenLParen, text: "(", pos: pos})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{kind: tokenRParen, text: ")", pos: pos})
+			i++
+		case r == '-':
+			tokens = append(tokens, token{kind: tokenMinus, text: "-", pos: pos})
+			i++
+		case r == '"':
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			if end == len(runes) {
+				return nil, &Error{Pos: pos, Token: string(runes[i:]), Msg: "unterminated phrase"}
+			}
+			tokens = append(tokens, token{kind: tokenPhrase, text: string(runes[i+1 : end]), pos: pos})
+			i = end + 1
+		default:
+			end := i
+			for end < len(runes) && !unicode.IsSpace(runes[end]) && !strings.ContainsRune(`()"`, runes[end]) {
+				end++
+			}
+			text := string(runes[i:end])
+			kind := tokenWord
+			if text == "OR" {
+				kind = tokenOr
+			}
+			tokens = append(tokens, token{kind: kind, text: text, pos: pos})
+			i = end
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(runes) + 1}), nil
+}
+
+type parser struct {
+	tokens []token
+	i      int
+	terms  int
+	// since and until are kept to check that they make a range.
+	since *bound
+	until *bound
+}
+
+type bound struct {
+	token
+	time time.Time
+}
+
+// Parse parses and validates a query. It returns nil for a query without
+// any terms, and an *Error if the query isn't valid.
+func Parse(q string) (Node, error) {
+	tokens, err := lex(q)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	if p.peek().kind == tokenEOF {
+		return nil, nil
+	}
+
+	n, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		// Only an unmatched ) stops the top-level list early.
+		return nil, &Error{Pos: t.pos, Token: t.text, Msg: "unmatched closing parenthesis"}
+	}
+
+	if p.since != nil && p.until != nil {
+		if !p.since.time.Before(p.until.time) {
+			return nil, &Error{Pos: p.until.pos, Token: p.until.text, Msg: "until must be after since"}
+		}
+	}
+	return n, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.i]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.i]
+	if t.kind != tokenEOF {
+		p.i++
+	}
+	return t
+}
+
+// and parses terms up to the end of the query or of a group.
+func (p *parser) and() (Node, error) {
+	var nodes []Node
+	for {
+		switch p.peek().kind {
+		case tokenEOF, tokenRParen:
+			if len(nodes) == 1 {
+				return nodes[0], nil
+			}
+			return And{Nodes: nodes}, nil
+		}
+		n, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+	}
+}
+
+func (p *parser) or() (Node, error) {
+	if t := p.peek(); t.kind == tokenOr {
+		return nil, &Error{Pos: t.pos, Token: t.text, Msg: "OR needs a term before it"}
+	}
+	n, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	nodes := []Node{n}
+	for p.peek().kind == tokenOr {
+		op := p.next()
+		switch p.peek().kind {
+		case tokenEOF, tokenRParen, tokenOr:
+			return nil, &Error{Pos: op.pos, Token: op.text, Msg: "OR needs a term after it"}
+		}
+		n, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+	}
+	if len(nodes) == 1 {
+		return n, nil
+	}
+	return Or{Nodes: nodes}, nil
+}
+
+func (p *parser) unary() (Node, error) {
+	if p.peek().kind != tokenMinus {
+		return p.primary()
+	}
+	minus := p.next()
+	switch p.peek().kind {
+	case tokenEOF, tokenRParen, tokenOr, tokenMinus:
+		return nil, &Error{Pos: minus.pos, Token: minus.text, Msg: "- needs a term after it"}
+	}
+	n, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	return Not{Node: n}, nil
+}
+
+func (p *parser) primary() (Node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenLParen:
+		if p.peek().kind == tokenRParen {
+			return nil, &Error{Pos: t.pos, Token: "()", Msg: "empty group"}
+		}
+		n, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		if p.next().kind != tokenRParen {
+			return nil, &Error{Pos: t.pos, Token: t.text, Msg: "unmatched opening parenthesis"}
+		}
+		return n, nil
+	case tokenPhrase:
+		if err := p.count(t); err != nil {
+			return nil, err
+		}
+		text := strings.Join(strings.Fields(t.text), " ")
+		if !hasAlphanumeric(text) {
+			return nil, &Error{Pos: t.pos, Token: `"` + t.text + `"`, Msg: "phrase has no words"}
+		}
+		return Phrase{Text: text}, nil
+	case tokenWord:
+		if err := p.count(t); err != nil {
+			return nil, err
+		}
+		return p.term(t)
+	default:
+		return nil, &Error{Pos: t.pos, Token: t.text, Msg: "unexpected token"}
+	}
+}
+
+func (p *parser) count(t token) error {
+	p.terms++
+	if p.terms > MaxTerms {
+		return &Error{Pos: t.pos, Token: t.text, Msg: fmt.Sprintf("query has more than %d terms", MaxTerms)}
+	}
+	return nil
+}
+
+// term turns a word into a Word, or into an operator if it has the form
+// name:value with a name made of letters. Values starting with // are left
+// as words, so pasted links and times like 12:30 still search.
+func (p *parser) term(t token) (Node, error) {
+	name, value, ok := strings.Cut(t.text, ":")
+	if !ok || !isLetters(name) || strings.HasPrefix(value, "//") {
+		if !hasAlphanumeric(t.text) {
+			return nil, &Error{Pos: t.pos, Token: t.text, Msg: "term has no letters or digits"}
+		}
+		return Word{Text: t.text}, nil
+	}
+
+	fail := func(msg string) (Node, error) {
+		return nil, &Error{Pos: t.pos, Token: t.text, Msg: msg}
+	}
+	if value == "" {
+		return fail("operator " + name + ": needs a value")
+	}
+
+	switch strings.ToLower(name) {
+	case "from":
+		id, err := uuid.Parse(value)
+		if err != nil {
+			return fail("from: needs a user ID")
+		}
+		return From{UserID: id}, nil
+	case "since", "until":
+		day, err := time.Parse(time.DateOnly, value)
+		if err != nil {
+			return fail(name + ": needs a date as YYYY-MM-DD")
+		}
+		if strings.ToLower(name) == "since" {
+			if p.since != nil {
+				return fail("since: can only be given once")
+			}
+			p.since = &bound{token: t, time: day}
+			return Since{Time: day}, nil
+		}
+		if p.until != nil {
+			return fail("until: can only be given once")
+		}
+		p.until = &bound{token: t, time: day}
+		return Until{Time: day}, nil
+	case "has":
+		if strings.ToLower(value) != "links" {
+			return fail("has: only supports links")
+		}
+		return HasLinks{}, nil
+	case "lang":
+		code := strings.ToLower(value)
+		if !IsLanguageCode(code) {
+			return fail("lang: needs a two-letter language code")
+		}
+		return Lang{Code: code}, nil
+	default:
+		return fail("unknown operator " + name)
+	}
+}
+
+// IsLanguageCode reports whether s has the form of an ISO 639-1 code: two
+// lowercase ASCII letters.
+func IsLanguageCode(s string) bool {
+	if len(s) != 2 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < 'a' || s[i] > 'z' {
+			return false
+		}
+	}
+	return true
+}
+
+func isLetters(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if !unicode.IsLetter(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func hasAlphanumeric(s string) bool {
+	for len(s) > 0 {
+		r, size := utf8.DecodeRuneInString(s)
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return true
+		}
+		s = s[size:]
+	}
+	return false
+}
@@ -0,0 +1,134 @@
+package search
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{name: "Empty query", query: "   ", want: "<nil>"},
+		{name: "Single word", query: "hello", want: `"hello"`},
+		{name: "Words are ANDed", query: "hello world", want: `and("hello" "world")`},
+		{name: "Phrase", query: `"hello  world"`, want: `phrase("hello world")`},
+		{name: "Exclusion", query: "go -java", want: `and("go" not("java"))`},
+		{name: "OR binds tighter than AND", query: "a b OR c d", want: `and("a" or("b" "c") "d")`},
+		{name: "Lowercase or is a word", query: "a or b", want: `and("a" "or" "b")`},
+		{name: "Groups", query: "-(a OR b) c", want: `and(not(or("a" "b")) "c")`},
+		{name: "Hyphenated word", query: "e-mail", want: `"e-mail"`},
+		{name: "From user ID", query: "from:6b1d4c3e-0f7a-4f8e-9d5b-2a6c8e1f3b7d", want: "from(6b1d4c3e-0f7a-4f8e-9d5b-2a6c8e1f3b7d)"},
+		{name: "Date range", query: "since:2025-01-01 until:2025-02-01", want: "and(since(2025-01-01) until(2025-02-01))"},
+		{name: "Operators ignore case", query: "HAS:Links LANG:EN", want: "and(has(links) lang(en))"},
+		{name: "Links are words", query: "https://example.com", want: `"https://example.com"`},
+		{name: "Times are words", query: "12:30", want: `"12:30"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := Parse(tt.query)
+			if err != nil {
+				t.Fatalf("Parse(%q) error = %v", tt.query, err)
+			}
+			got := "<nil>"
+			if n != nil {
+				got = n.String()
+			}
+			if got != tt.want {
+				t.Errorf("Parse(%q) = %s, want %s", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		wantPos   int
+		wantToken string
+	}{
+		{name: "Unknown operator", query: "hello color:red", wantPos: 7, wantToken: "color:red"},
+		{name: "Operator without value", query: "from:", wantPos: 1, wantToken: "from:"},
+		{name: "Bad date", query: "since:yesterday", wantPos: 1, wantToken: "since:yesterday"},
+		{name: "Empty range", query: "since:2025-02-01 until:2025-01-01", wantPos: 18, wantToken: "until:2025-01-01"},
+		{name: "Repeated since", query: "since:2025-01-01 since:2025-02-01", wantPos: 18, wantToken: "since:2025-02-01"},
+		{name: "Unsupported has", query: "has:images", wantPos: 1, wantToken: "has:images"},
+		{name: "Bad language", query: "lang:english", wantPos: 1, wantToken: "lang:english"},
+		{name: "Bad author", query: "from:alice", wantPos: 1, wantToken: "from:alice"},
+		{name: "Author by email", query: "from:alice@example.com", wantPos: 1, wantToken: "from:alice@example.com"},
+		{name: "Unterminated phrase", query: `a "b c`, wantPos: 3, wantToken: `"b c`},
+		{name: "Leading OR", query: "OR a", wantPos: 1, wantToken: "OR"},
+		{name: "Trailing OR", query: "a OR", wantPos: 3, wantToken: "OR"},
+		{name: "Dangling minus", query: "a -", wantPos: 3, wantToken: "-"},
+		{name: "Unclosed group", query: "(a b", wantPos: 1, wantToken: "("},
+		{name: "Unopened group", query: "a) b", wantPos: 2, wantToken: ")"},
+		{name: "Empty group", query: "a ()", wantPos: 3, wantToken: "()"},
+		{name: "Punctuation only", query: "!!", wantPos: 1, wantToken: "!!"},
+		{name: "Too many terms", query: "a b c d e f g h i j k l m n o p q r s t u", wantPos: 41, wantToken: "u"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.query)
+			var queryErr *Error
+			if !errors.As(err, &queryErr) {
+				t.Fatalf("Parse(%q) error = %v, want *Error", tt.query, err)
+			}
+			if queryErr.Pos != tt.wantPos || queryErr.Token != tt.wantToken {
+				t.Errorf("Parse(%q) error at %d %q, want %d %q", tt.query, queryErr.Pos, queryErr.Token, tt.wantPos, tt.wantToken)
+			}
+		})
+	}
+}
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		wantSQL  string
+		wantArgs []any
+	}{
+		{
+			name:     "Word",
+			query:    "hello",
+			wantSQL:  "chirps.search_vector @@ plainto_tsquery('english', $5)",
+			wantArgs: []any{"hello"},
+		},
+		{
+			name:     "Phrase escapes LIKE patterns",
+			query:    `"100% done_"`,
+			wantSQL:  "(chirps.search_vector @@ phraseto_tsquery('english', $5) AND chirps.body ILIKE $6)",
+			wantArgs: []any{"100% done_", `%100\% done\_%`},
+		},
+		{
+			name:     "Operators",
+			query:    "from:6b1d4c3e-0f7a-4f8e-9d5b-2a6c8e1f3b7d -has:links (lang:en OR until:2025-01-01)",
+			wantSQL:  "(chirps.user_id = $5 AND NOT (chirps.body ~* 'https?://') AND (chirps.lang = $6 OR chirps.created_at < $7))",
+			wantArgs: []any{uuid.MustParse("6b1d4c3e-0f7a-4f8e-9d5b-2a6c8e1f3b7d"), "en", time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := Parse(tt.query)
+			if err != nil {
+				t.Fatalf("Parse(%q) error = %v", tt.query, err)
+			}
+			gotSQL, gotArgs := Compile(n, 5)
+			if gotSQL != tt.wantSQL {
+				t.Errorf("Compile() SQL = %s, want %s", gotSQL, tt.wantSQL)
+			}
+			if !reflect.DeepEqual(gotArgs, tt.wantArgs) {
+				t.Errorf("Compile() args = %v, want %v", gotArgs, tt.wantArgs)
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"encoding/json"
+	"net"
 	"net/http"
 )
 
@@ -9,9 +10,38 @@ func RespondWithError(w http.ResponseWriter, code int, msg string) {
 	RespondWithJSON(w, code, map[string]string{"error": msg})
 }
 
+// FieldError describes why one field of a request was rejected.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// RespondWithValidationErrors rejects a request with every problem found in
+// it, so clients can show them all at once.
+func RespondWithValidationErrors(w http.ResponseWriter, errs []FieldError) {
+	type response struct {
+		Error  string       `json:"error"`
+		Errors []FieldError `json:"errors"`
+	}
+	RespondWithJSON(w, http.StatusBadRequest, response{
+		Error:  "Validation failed",
+		Errors: errs,
+	})
+}
+
 func RespondWithJSON(w http.ResponseWriter, code int, payload any) {
 	response, _ := json.Marshal(payload)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)
 	w.Write(response)
 }
+
+// ClientIP returns the IP address of the client that sent the request.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -90,17 +90,32 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
 	"sync/atomic"
+	"time"
 
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 	"github.com/yujen77300/Chirpy-Server/internal/api"
+	"github.com/yujen77300/Chirpy-Server/internal/api/handlers"
+	"github.com/yujen77300/Chirpy-Server/internal/auth"
 	"github.com/yujen77300/Chirpy-Server/internal/database"
+	"github.com/yujen77300/Chirpy-Server/internal/deletion"
+	"github.com/yujen77300/Chirpy-Server/internal/lockout"
+	"github.com/yujen77300/Chirpy-Server/internal/mailer"
+	"github.com/yujen77300/Chirpy-Server/internal/oidc"
+	"github.com/yujen77300/Chirpy-Server/internal/revocation"
 )
 
 func main() {
@@ -113,32 +128,196 @@ func main() {
 	if dbURL == "" {
 		log.Fatal("DB_URL must be set")
 	}
-	platform := os.Getenv("PLATFORM")
-	if platform == "" {
-		log.Fatal("PLATFORM must be set")
+	db, err := sql.Open("postgres", dbURL)
+	if err != nil {
+		log.Fatalf("Error opening database: %s", err)
+	}
+	dbQueries := database.New(db)
+
+	if len(os.Args) > 1 {
+		err := runCommand(dbQueries, os.Args[1:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
+
 	jwtSecret := os.Getenv("SECRET")
-	if jwtSecret == "" {
-		log.Fatal("SECRET environment variable is not set")
+	jwtKeysDir := os.Getenv("JWT_KEYS_DIR")
+	if jwtSecret == "" && jwtKeysDir == "" {
+		log.Fatal("SECRET or JWT_KEYS_DIR environment variable must be set")
 	}
 	polkaKey := os.Getenv("POLKA_KEY")
 	if polkaKey == "" {
 		log.Fatal("POLKA_KEY environment variable is not set")
 	}
+	platform := os.Getenv("PLATFORM")
+	if platform == "" {
+		log.Fatal("PLATFORM must be set")
+	}
 
-	db, err := sql.Open("postgres", dbURL)
+	baseURL := os.Getenv("BASE_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:8080"
+	}
+
+	mailFrom := os.Getenv("MAIL_FROM")
+	if mailFrom == "" {
+		mailFrom = "no-reply@chirpy.local"
+	}
+	var mail mailer.Mailer = mailer.NewLogMailer()
+	if smtpHost := os.Getenv("SMTP_HOST"); smtpHost != "" {
+		smtpPort := os.Getenv("SMTP_PORT")
+		if smtpPort == "" {
+			smtpPort = "587"
+		}
+		mail = mailer.NewSMTPMailer(smtpHost, smtpPort, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), mailFrom)
+	} else if mailDir := os.Getenv("MAIL_DIR"); mailDir != "" {
+		mail = mailer.NewFileMailer(mailDir, mailFrom)
+	}
+
+	verification, err := handlers.ParseVerificationPolicy(os.Getenv("UNVERIFIED_EMAIL_RESTRICTIONS"))
 	if err != nil {
-		log.Fatalf("Error opening database: %s", err)
+		log.Fatalf("Invalid UNVERIFIED_EMAIL_RESTRICTIONS: %s", err)
+	}
+
+	// Passkeys are bound to the site's domain, which defaults to the host of
+	// BASE_URL.
+	rpID := os.Getenv("WEBAUTHN_RP_ID")
+	if rpID == "" {
+		u, err := url.Parse(baseURL)
+		if err != nil {
+			log.Fatalf("Invalid BASE_URL: %s", err)
+		}
+		rpID = u.Hostname()
+	}
+	rpOrigins := []string{baseURL}
+	if origins := os.Getenv("WEBAUTHN_RP_ORIGINS"); origins != "" {
+		rpOrigins = strings.Split(origins, ",")
+	}
+	webAuthn, err := webauthn.New(&webauthn.Config{
+		RPID:          rpID,
+		RPDisplayName: "Chirpy",
+		RPOrigins:     rpOrigins,
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementRequired,
+			UserVerification: protocol.VerificationRequired,
+		},
+	})
+	if err != nil {
+		log.Fatalf("Invalid WebAuthn configuration: %s", err)
+	}
+
+	// Single sign-on through an external OpenID Connect provider is optional.
+	var oidcProvider *oidc.Provider
+	if issuer := os.Getenv("OIDC_ISSUER"); issuer != "" {
+		clientID := os.Getenv("OIDC_CLIENT_ID")
+		if clientID == "" {
+			log.Fatal("OIDC_CLIENT_ID must be set when OIDC_ISSUER is")
+		}
+		oidcProvider = oidc.NewProvider(oidc.Config{
+			Issuer:       issuer,
+			ClientID:     clientID,
+			ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL:  baseURL + "/api/login/oidc/callback",
+		})
+	}
+
+	argon2Params, err := argon2ParamsFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid password hashing parameters: %s", err)
+	}
+	auth.DefaultPasswordHasher = auth.NewPasswordHasher(argon2Params)
+
+	passwordPolicy, err := passwordPolicyFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid password policy: %s", err)
+	}
+	auth.DefaultPasswordPolicy = passwordPolicy
+
+	// Keep failed logins in Postgres when running several instances.
+	var loginAttempts lockout.Store = lockout.NewMemoryStore()
+	if os.Getenv("LOGIN_ATTEMPT_STORE") == "postgres" {
+		loginAttempts = lockout.NewPostgresStore(dbQueries)
+	}
+	loginGuard := lockout.NewGuard(loginAttempts, lockout.DefaultAccountPolicy, lockout.DefaultIPPolicy)
+	// Login link requests are counted apart from failed logins, so asking
+	// for links never locks anyone out of logging in with a password.
+	magicLinkGuard := lockout.NewNamespacedGuard(loginAttempts, "magic-link:", lockout.DefaultMagicLinkPolicy, lockout.DefaultMagicLinkIPPolicy)
+	// Verification and password reset emails are limited like login links.
+	verificationGuard := lockout.NewNamespacedGuard(loginAttempts, "verification:", lockout.DefaultMagicLinkPolicy, lockout.DefaultMagicLinkIPPolicy)
+	passwordResetGuard := lockout.NewNamespacedGuard(loginAttempts, "password-reset:", lockout.DefaultMagicLinkPolicy, lockout.DefaultMagicLinkIPPolicy)
+	// Passkey logins have no account yet, so only the IP policy applies.
+	passkeyLoginGuard := lockout.NewNamespacedGuard(loginAttempts, "passkey-login:", lockout.DefaultAccountPolicy, lockout.DefaultPasskeyLoginIPPolicy)
+
+	jwtKeys := auth.NewHMACKeySet(jwtSecret)
+	if jwtKeysDir != "" {
+		jwtKeys, err = auth.LoadKeySet(jwtKeysDir, os.Getenv("JWT_ACTIVE_KID"))
+		if err != nil {
+			log.Fatalf("Error loading JWT keys: %s", err)
+		}
+		// Keep accepting HS256 tokens issued before the switch to asymmetric keys.
+		if jwtSecret != "" {
+			jwtKeys.AddHMACKey("hs256", jwtSecret)
+		}
+	}
+
+	// Revoked access tokens are kept in Postgres and checked from memory.
+	// Revocations made by other instances apply after the next refresh.
+	revocations := revocation.NewList(revocation.NewPostgresStore(dbQueries), time.Hour)
+	if err := revocations.Refresh(context.Background()); err != nil {
+		log.Fatalf("Error loading token revocations: %s", err)
+	}
+	go revocations.Run(context.Background(), time.Second*10)
+	jwtKeys.SetRevocationChecker(revocations)
+
+	deletionGracePeriod := deletion.DefaultGracePeriod
+	if v := os.Getenv("ACCOUNT_DELETION_GRACE_DAYS"); v != "" {
+		days, err := strconv.Atoi(v)
+		if err != nil || days < 0 {
+			log.Fatal("ACCOUNT_DELETION_GRACE_DAYS must be a number of days")
+		}
+		deletionGracePeriod = time.Hour * 24 * time.Duration(days)
+	}
+	go deletion.NewPurger(dbQueries, deletionGracePeriod).Run(context.Background(), time.Hour)
+	go purgeExpiredWebAuthnSessions(context.Background(), dbQueries, time.Minute*10)
+
+	chirpEdits := handlers.DefaultChirpEditPolicy
+	if v := os.Getenv("CHIRP_EDIT_WINDOW_MINUTES"); v != "" {
+		minutes, err := strconv.Atoi(v)
+		if err != nil || minutes < 0 {
+			log.Fatal("CHIRP_EDIT_WINDOW_MINUTES must be a number of minutes")
+		}
+		chirpEdits.Window = time.Minute * time.Duration(minutes)
+	}
+	if v := os.Getenv("CHIRP_EDIT_WINDOW_RED_MINUTES"); v != "" {
+		minutes, err := strconv.Atoi(v)
+		if err != nil || minutes < 0 {
+			log.Fatal("CHIRP_EDIT_WINDOW_RED_MINUTES must be a number of minutes")
+		}
+		chirpEdits.RedWindow = time.Minute * time.Duration(minutes)
 	}
-	dbQueries := database.New(db)
 
 	var hits atomic.Int32
 	server := api.NewServer(api.ServerConfig{
-		DB:             dbQueries,
-		Platform:       platform,
-		JWTSecret:      jwtSecret,
-		PolkaKey:       polkaKey,
-		FileserverHits: &hits,
+		DB:                  dbQueries,
+		JWTKeys:             jwtKeys,
+		PolkaKey:            polkaKey,
+		Platform:            platform,
+		FileserverHits:      &hits,
+		Mailer:              mail,
+		BaseURL:             baseURL,
+		Verification:        verification,
+		LoginGuard:          loginGuard,
+		MagicLinkGuard:      magicLinkGuard,
+		VerificationGuard:   verificationGuard,
+		PasswordResetGuard:  passwordResetGuard,
+		PasskeyLoginGuard:   passkeyLoginGuard,
+		Revocations:         revocations,
+		DeletionGracePeriod: deletionGracePeriod,
+		ChirpEdits:          chirpEdits,
+		WebAuthn:            webAuthn,
+		OIDCProvider:        oidcProvider,
 	})
 
 	fmt.Println("Starting server on :8080")
@@ -146,3 +325,85 @@ func main() {
 		fmt.Println("Server failed:", err)
 	}
 }
+
+// purgeExpiredWebAuthnSessions deletes unfinished passkey ceremonies every
+// interval until ctx is done. Passkey logins are started without logging
+// in, so their sessions would otherwise pile up.
+func purgeExpiredWebAuthnSessions(ctx context.Context, db *database.Queries, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := db.DeleteExpiredWebAuthnSessions(ctx)
+			if err != nil {
+				log.Printf("Error purging expired passkey sessions: %s", err)
+				continue
+			}
+			if n > 0 {
+				log.Printf("Purged %d expired passkey sessions", n)
+			}
+		}
+	}
+}
+
+// argon2ParamsFromEnv starts from the default argon2id parameters and applies
+// ARGON2_MEMORY_KIB, ARGON2_ITERATIONS and ARGON2_PARALLELISM when set.
+// Existing hashes are upgraded on the next login after a change.
+func argon2ParamsFromEnv() (auth.Argon2Params, error) {
+	params := auth.DefaultArgon2Params
+	if v := os.Getenv("ARGON2_MEMORY_KIB"); v != "" {
+		memory, err := strconv.ParseUint(v, 10, 32)
+		if err != nil || memory < 8*1024 {
+			return params, errors.New("ARGON2_MEMORY_KIB must be a number of at least 8192")
+		}
+		params.Memory = uint32(memory)
+	}
+	if v := os.Getenv("ARGON2_ITERATIONS"); v != "" {
+		iterations, err := strconv.ParseUint(v, 10, 32)
+		if err != nil || iterations < 1 {
+			return params, errors.New("ARGON2_ITERATIONS must be a positive number")
+		}
+		params.Iterations = uint32(iterations)
+	}
+	if v := os.Getenv("ARGON2_PARALLELISM"); v != "" {
+		parallelism, err := strconv.ParseUint(v, 10, 8)
+		if err != nil || parallelism < 1 {
+			return params, errors.New("ARGON2_PARALLELISM must be a number between 1 and 255")
+		}
+		params.Parallelism = uint8(parallelism)
+	}
+	return params, nil
+}
+
+// passwordPolicyFromEnv starts from the default password policy and applies
+// PASSWORD_MIN_LENGTH, PASSWORD_MAX_LENGTH and BREACHED_PASSWORDS_FILE when
+// set. The policy only applies to new passwords.
+func passwordPolicyFromEnv() (auth.PasswordPolicy, error) {
+	policy := auth.DefaultPasswordPolicy
+	if v := os.Getenv("PASSWORD_MIN_LENGTH"); v != "" {
+		minLength, err := strconv.Atoi(v)
+		if err != nil || minLength < 1 {
+			return policy, errors.New("PASSWORD_MIN_LENGTH must be a positive number")
+		}
+		policy.MinLength = minLength
+	}
+	if v := os.Getenv("PASSWORD_MAX_LENGTH"); v != "" {
+		maxLength, err := strconv.Atoi(v)
+		if err != nil || maxLength < policy.MinLength {
+			return policy, errors.New("PASSWORD_MAX_LENGTH must be a number no less than PASSWORD_MIN_LENGTH")
+		}
+		policy.MaxLength = maxLength
+	}
+	if path := os.Getenv("BREACHED_PASSWORDS_FILE"); path != "" {
+		breached, err := auth.OpenBreachedPasswordFile(path)
+		if err != nil {
+			return policy, fmt.Errorf("opening BREACHED_PASSWORDS_FILE: %w", err)
+		}
+		policy.Breached = breached
+	}
+	return policy, nil
+}
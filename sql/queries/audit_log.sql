@@ -0,0 +1,15 @@
+-- name: CreateAuditLogEntry :exec
+INSERT INTO audit_log(id, action, actor_id, subject_id, details, created_at)
+VALUES (
+    gen_random_uuid(),
+    $1,
+    $2,
+    $3,
+    $4,
+    NOW()
+);
+
+-- name: GetAuditLog :many
+SELECT * FROM audit_log
+ORDER BY created_at DESC
+LIMIT $1;
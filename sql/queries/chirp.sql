@@ -1,32 +1,173 @@
 -- name: CreateChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, lang, in_reply_to, root_id)
 VALUES (
     gen_random_uuid(),
     NOW(),
     NOW(),
     $1,
-    $2
+    $2,
+    $3,
+    $4,
+    $5
 )
 RETURNING *;
 
 
--- name: GetChirps :many
-SELECT * FROM chirps
-ORDER BY created_at ASC;
-
 -- name: GetChirp :one
-SELECT * FROM chirps
-WHERE id = $1;
+SELECT chirps.* FROM chirps
+JOIN users ON users.id = chirps.user_id
+WHERE chirps.id = $1
+AND chirps.deleted_at IS NULL
+AND users.deactivated_at IS NULL;
 
 -- name: GetChirpByID :one
-SELECT * FROM chirps
-WHERE id = $1;
+SELECT chirps.* FROM chirps
+JOIN users ON users.id = chirps.user_id
+WHERE chirps.id = $1
+AND chirps.deleted_at IS NULL
+AND users.deactivated_at IS NULL;
+
+-- name: ChirpExists :one
+SELECT EXISTS (SELECT 1 FROM chirps WHERE chirps.id = $1);
+
+-- name: LockChirp :exec
+SELECT id FROM chirps
+WHERE id = $1
+FOR UPDATE;
+
+-- name: DeleteChirp :one
+DELETE FROM chirps
+WHERE id = $1
+RETURNING in_reply_to;
 
--- name: DeleteChirp :exec
+-- name: DeleteEmptyTombstone :one
 DELETE FROM chirps
-WHERE id = $1;
+WHERE id = $1
+AND deleted_at IS NOT NULL
+AND NOT EXISTS (SELECT 1 FROM chirps replies WHERE replies.in_reply_to = chirps.id)
+RETURNING in_reply_to;
+
+-- name: TombstoneChirp :execrows
+WITH revisions AS (
+    DELETE FROM chirp_revisions
+    WHERE chirp_id = $1
+    AND EXISTS (SELECT 1 FROM chirps replies WHERE replies.in_reply_to = $1)
+)
+UPDATE chirps
+SET body = '', lang = NULL, deleted_at = NOW()
+WHERE id = $1
+AND EXISTS (SELECT 1 FROM chirps replies WHERE replies.in_reply_to = chirps.id);
+
+-- name: HandOverRepliedChirps :execrows
+WITH revisions AS (
+    DELETE FROM chirp_revisions
+    USING chirps, users
+    WHERE chirp_revisions.chirp_id = chirps.id
+    AND users.id = chirps.user_id
+    AND users.deactivated_at < sqlc.arg(deactivated_before)::timestamp
+    AND EXISTS (SELECT 1 FROM chirps replies WHERE replies.in_reply_to = chirps.id)
+)
+UPDATE chirps
+SET user_id = sqlc.arg(deleted_author_id)::uuid, body = '', lang = NULL, deleted_at = COALESCE(chirps.deleted_at, NOW())
+FROM users
+WHERE users.id = chirps.user_id
+AND users.deactivated_at < sqlc.arg(deactivated_before)::timestamp
+AND EXISTS (SELECT 1 FROM chirps replies WHERE replies.in_reply_to = chirps.id);
+
+-- name: DeleteEmptyTombstones :execrows
+DELETE FROM chirps
+WHERE deleted_at IS NOT NULL
+AND NOT EXISTS (SELECT 1 FROM chirps replies WHERE replies.in_reply_to = chirps.id);
+
+-- name: ListRepliesAfter :many
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.lang,
+    chirps.in_reply_to, chirps.root_id, chirps.deleted_at,
+    users.deactivated_at IS NOT NULL AS author_deactivated
+FROM chirps
+JOIN users ON users.id = chirps.user_id
+WHERE chirps.in_reply_to = sqlc.arg(parent_id)::uuid
+AND (sqlc.narg(cursor_created_at)::timestamp IS NULL OR (chirps.created_at, chirps.id) > (sqlc.narg(cursor_created_at), sqlc.narg(cursor_id)::uuid))
+ORDER BY chirps.created_at ASC, chirps.id ASC
+LIMIT sqlc.arg(row_limit)::int;
+
+-- name: GetChirpThread :many
+WITH RECURSIVE ancestors AS (
+    SELECT chirps.id, chirps.in_reply_to FROM chirps
+    WHERE chirps.id = sqlc.arg(chirp_id)::uuid
+    UNION ALL
+    SELECT parent.id, parent.in_reply_to FROM chirps parent
+    JOIN ancestors ON parent.id = ancestors.in_reply_to
+), descendants AS (
+    -- Walked a level at a time and only as far as the LIMIT below reads, so
+    -- a large conversation isn't scanned in full.
+    SELECT chirps.id FROM chirps
+    WHERE chirps.in_reply_to = sqlc.arg(chirp_id)::uuid
+    UNION ALL
+    SELECT reply.id FROM chirps reply
+    JOIN descendants ON reply.in_reply_to = descendants.id
+)
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.lang,
+    chirps.in_reply_to, chirps.root_id, chirps.deleted_at,
+    users.deactivated_at IS NOT NULL AS author_deactivated
+FROM chirps
+JOIN users ON users.id = chirps.user_id
+WHERE chirps.id IN (SELECT ancestors.id FROM ancestors UNION ALL (SELECT descendants.id FROM descendants LIMIT sqlc.arg(row_limit)::int))
+ORDER BY chirps.created_at ASC, chirps.id ASC
+LIMIT sqlc.arg(row_limit)::int;
+
+-- name: UpdateChirpBody :one
+WITH revision AS (
+    INSERT INTO chirp_revisions (id, chirp_id, body, created_at, replaced_at)
+    SELECT gen_random_uuid(), chirps.id, chirps.body, chirps.updated_at, NOW()
+    FROM chirps
+    WHERE chirps.id = $1
+    FOR UPDATE
+)
+UPDATE chirps
+SET body = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING chirps.*;
+
+-- name: GetChirpRevisions :many
+SELECT * FROM chirp_revisions
+WHERE chirp_id = $1
+ORDER BY replaced_at DESC, id DESC;
+
+-- name: ListChirpsAfter :many
+SELECT chirps.* FROM chirps
+JOIN users ON users.id = chirps.user_id
+WHERE users.deactivated_at IS NULL
+AND chirps.deleted_at IS NULL
+AND (sqlc.narg(author_id)::uuid IS NULL OR chirps.user_id = sqlc.narg(author_id))
+AND (sqlc.narg(cursor_created_at)::timestamp IS NULL OR (chirps.created_at, chirps.id) > (sqlc.narg(cursor_created_at), sqlc.narg(cursor_id)::uuid))
+ORDER BY chirps.created_at ASC, chirps.id ASC
+LIMIT sqlc.arg(row_limit)::int;
+
+-- name: ListChirpsBefore :many
+SELECT chirps.* FROM chirps
+JOIN users ON users.id = chirps.user_id
+WHERE users.deactivated_at IS NULL
+AND chirps.deleted_at IS NULL
+AND (sqlc.narg(author_id)::uuid IS NULL OR chirps.user_id = sqlc.narg(author_id))
+AND (sqlc.narg(cursor_created_at)::timestamp IS NULL OR (chirps.created_at, chirps.id) < (sqlc.narg(cursor_created_at), sqlc.narg(cursor_id)::uuid))
+ORDER BY chirps.created_at DESC, chirps.id DESC
+LIMIT sqlc.arg(row_limit)::int;
 
--- name: GetChirpsByAuthorID :many
-SELECT * FROM chirps
-WHERE user_id = $1
-ORDER BY created_at ASC;
\ No newline at end of file
+-- name: SearchChirps :many
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.lang, chirps.in_reply_to, chirps.root_id,
+    ts_rank(chirps.search_vector, websearch_to_tsquery('english', sqlc.arg(query)::text)) AS rank,
+    ts_headline(
+        'english',
+        replace(replace(replace(chirps.body, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'),
+        websearch_to_tsquery('english', sqlc.arg(query)::text),
+        'StartSel=<mark>, StopSel=</mark>, MaxWords=20, MinWords=8'
+    ) AS snippet
+FROM chirps
+JOIN users ON users.id = chirps.user_id
+WHERE chirps.search_vector @@ websearch_to_tsquery('english', sqlc.arg(query)::text)
+AND users.deactivated_at IS NULL
+AND chirps.deleted_at IS NULL
+AND (sqlc.narg(author_id)::uuid IS NULL OR chirps.user_id = sqlc.narg(author_id))
+AND (sqlc.narg(cursor_rank)::real IS NULL OR (ts_rank(chirps.search_vector, websearch_to_tsquery('english', sqlc.arg(query)::text)), chirps.id) < (sqlc.narg(cursor_rank), sqlc.narg(cursor_id)::uuid))
+ORDER BY rank DESC, chirps.id DESC
+LIMIT sqlc.arg(row_limit)::int;
@@ -0,0 +1,21 @@
+-- name: GetLoginAttempt :one
+SELECT * FROM login_attempts
+WHERE key = $1;
+
+-- name: RecordLoginFailure :one
+INSERT INTO login_attempts(key, failures, last_failure_at)
+VALUES (
+    sqlc.arg(key),
+    1,
+    sqlc.arg(now)::TIMESTAMP
+)
+ON CONFLICT (key) DO UPDATE SET failures = CASE
+    WHEN login_attempts.last_failure_at < sqlc.arg(reset_before)::TIMESTAMP THEN 1
+    ELSE login_attempts.failures + 1
+END,
+last_failure_at = sqlc.arg(now)::TIMESTAMP
+RETURNING *;
+
+-- name: DeleteLoginAttempt :exec
+DELETE FROM login_attempts
+WHERE key = $1;
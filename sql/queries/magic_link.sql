@@ -0,0 +1,15 @@
+-- name: CreateMagicLinkToken :exec
+INSERT INTO magic_link_tokens(token_hash, created_at, expires_at, user_id)
+VALUES (
+    $1,
+    NOW(),
+    $2,
+    $3
+);
+
+-- name: UseMagicLinkToken :one
+UPDATE magic_link_tokens SET used_at = NOW()
+WHERE token_hash = $1
+AND used_at IS NULL
+AND expires_at > NOW()
+RETURNING *;
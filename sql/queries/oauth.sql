@@ -0,0 +1,118 @@
+-- name: CreateOAuthClient :one
+INSERT INTO oauth_clients(id, created_at, updated_at, name, secret_hash, redirect_uris, user_id)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4
+)
+RETURNING *;
+
+-- name: GetOAuthClient :one
+SELECT * FROM oauth_clients
+WHERE id = $1;
+
+-- name: GetUserOAuthClients :many
+SELECT * FROM oauth_clients
+WHERE user_id = $1
+ORDER BY created_at DESC;
+
+-- name: DeleteOAuthClient :execrows
+DELETE FROM oauth_clients
+WHERE id = $1
+AND user_id = $2;
+
+-- name: UpsertOAuthGrant :exec
+INSERT INTO oauth_grants(user_id, client_id, scopes, created_at, updated_at)
+VALUES (
+    $1,
+    $2,
+    $3,
+    NOW(),
+    NOW()
+)
+ON CONFLICT (user_id, client_id) DO UPDATE SET scopes = EXCLUDED.scopes,
+updated_at = NOW();
+
+-- name: GetOAuthGrant :one
+SELECT oauth_grants.* FROM oauth_grants
+JOIN users ON users.id = oauth_grants.user_id
+WHERE oauth_grants.user_id = $1
+AND oauth_grants.client_id = $2
+AND users.deactivated_at IS NULL;
+
+-- name: GetUserOAuthGrants :many
+SELECT oauth_grants.*, oauth_clients.name
+FROM oauth_grants
+JOIN oauth_clients ON oauth_clients.id = oauth_grants.client_id
+WHERE oauth_grants.user_id = $1
+ORDER BY oauth_grants.updated_at DESC;
+
+-- name: DeleteOAuthGrant :execrows
+DELETE FROM oauth_grants
+WHERE user_id = $1
+AND client_id = $2;
+
+-- name: DeleteUserOAuthGrants :exec
+DELETE FROM oauth_grants
+WHERE user_id = $1;
+
+-- name: CreateOAuthAuthorizationCode :exec
+INSERT INTO oauth_authorization_codes(code_hash, created_at, expires_at, redirect_uri, scopes, code_challenge, client_id, user_id)
+VALUES (
+    $1,
+    NOW(),
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    $7
+);
+
+-- name: UseOAuthAuthorizationCode :one
+UPDATE oauth_authorization_codes SET used_at = NOW()
+WHERE code_hash = $1
+AND client_id = $2
+AND redirect_uri = $3
+AND used_at IS NULL
+AND expires_at > NOW()
+RETURNING *;
+
+-- name: CreateOAuthRefreshToken :exec
+INSERT INTO oauth_refresh_tokens(token_hash, created_at, expires_at, scopes, client_id, user_id)
+VALUES (
+    $1,
+    NOW(),
+    $2,
+    $3,
+    $4,
+    $5
+);
+
+-- name: RotateOAuthRefreshToken :one
+UPDATE oauth_refresh_tokens SET revoked_at = NOW(), rotated_at = NOW()
+WHERE token_hash = $1
+AND client_id = $2
+AND revoked_at IS NULL
+AND expires_at > NOW()
+RETURNING *;
+
+-- name: GetOAuthRefreshToken :one
+SELECT * FROM oauth_refresh_tokens
+WHERE token_hash = $1
+AND client_id = $2;
+
+-- name: RevokeOAuthRefreshTokens :exec
+UPDATE oauth_refresh_tokens SET revoked_at = NOW()
+WHERE user_id = $1
+AND client_id = $2
+AND revoked_at IS NULL;
+
+-- name: RevokeUserOAuthRefreshTokens :exec
+UPDATE oauth_refresh_tokens SET revoked_at = NOW()
+WHERE user_id = $1
+AND revoked_at IS NULL;
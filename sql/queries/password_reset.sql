@@ -0,0 +1,27 @@
+-- name: CreatePasswordResetToken :exec
+INSERT INTO password_reset_tokens(token_hash, created_at, expires_at, user_id)
+VALUES (
+    $1,
+    NOW(),
+    $2,
+    $3
+);
+
+-- name: UsePasswordResetToken :one
+UPDATE password_reset_tokens SET used_at = NOW()
+WHERE token_hash = $1
+AND used_at IS NULL
+AND expires_at > NOW()
+RETURNING *;
+
+-- name: InvalidatePasswordResetTokens :exec
+UPDATE password_reset_tokens SET used_at = NOW()
+WHERE user_id = $1
+AND used_at IS NULL;
+
+-- name: GetPasswordResetTokenUser :one
+SELECT users.* FROM password_reset_tokens
+JOIN users ON users.id = password_reset_tokens.user_id
+WHERE password_reset_tokens.token_hash = $1
+AND password_reset_tokens.used_at IS NULL
+AND password_reset_tokens.expires_at > NOW();
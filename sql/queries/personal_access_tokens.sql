@@ -0,0 +1,41 @@
+-- name: CreatePersonalAccessToken :one
+INSERT INTO personal_access_tokens(id, name, token_hash, scopes, created_at, expires_at, user_id)
+VALUES (
+    gen_random_uuid(),
+    $1,
+    $2,
+    $3,
+    NOW(),
+    $4,
+    $5
+)
+RETURNING *;
+
+-- name: GetActivePersonalAccessToken :one
+SELECT personal_access_tokens.* FROM personal_access_tokens
+JOIN users ON users.id = personal_access_tokens.user_id
+WHERE personal_access_tokens.token_hash = $1
+AND personal_access_tokens.revoked_at IS NULL
+AND (personal_access_tokens.expires_at IS NULL OR personal_access_tokens.expires_at > NOW())
+AND users.deactivated_at IS NULL;
+
+-- name: GetUserPersonalAccessTokens :many
+SELECT * FROM personal_access_tokens
+WHERE user_id = $1
+AND revoked_at IS NULL
+ORDER BY created_at DESC;
+
+-- name: TouchPersonalAccessToken :exec
+UPDATE personal_access_tokens SET last_used_at = NOW()
+WHERE id = $1;
+
+-- name: RevokePersonalAccessToken :execrows
+UPDATE personal_access_tokens SET revoked_at = NOW()
+WHERE id = $1
+AND user_id = $2
+AND revoked_at IS NULL;
+
+-- name: RevokeUserPersonalAccessTokens :exec
+UPDATE personal_access_tokens SET revoked_at = NOW()
+WHERE user_id = $1
+AND revoked_at IS NULL;
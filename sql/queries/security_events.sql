@@ -0,0 +1,26 @@
+-- name: CreateSecurityEvent :exec
+INSERT INTO security_events(id, user_id, event_type, ip_address, user_agent, details, created_at)
+VALUES (
+    gen_random_uuid(),
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    NOW()
+);
+
+-- name: GetUserSecurityEvents :many
+SELECT * FROM security_events
+WHERE user_id = $1
+ORDER BY created_at DESC
+LIMIT $2;
+
+-- name: ListSecurityEvents :many
+SELECT * FROM security_events
+WHERE (sqlc.narg(user_id)::uuid IS NULL OR user_id = sqlc.narg(user_id))
+AND (sqlc.narg(event_type)::text IS NULL OR event_type = sqlc.narg(event_type))
+AND (sqlc.narg(since)::timestamp IS NULL OR created_at >= sqlc.narg(since))
+AND (sqlc.narg(until)::timestamp IS NULL OR created_at < sqlc.narg(until))
+ORDER BY created_at DESC
+LIMIT sqlc.arg(row_limit)::int;
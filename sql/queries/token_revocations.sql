@@ -0,0 +1,28 @@
+-- name: RevokeAccessToken :exec
+INSERT INTO revoked_access_tokens(jti, revoked_at, expires_at)
+VALUES (
+    $1,
+    NOW(),
+    $2
+)
+ON CONFLICT (jti) DO NOTHING;
+
+-- name: GetRevokedAccessTokens :many
+SELECT * FROM revoked_access_tokens
+WHERE expires_at > $1;
+
+-- name: DeleteExpiredRevokedAccessTokens :exec
+DELETE FROM revoked_access_tokens
+WHERE expires_at <= $1;
+
+-- name: SetAccessTokenWatermark :exec
+INSERT INTO access_token_watermarks(user_id, revoked_before)
+VALUES (
+    $1,
+    $2
+)
+ON CONFLICT (user_id) DO UPDATE SET revoked_before = GREATEST(access_token_watermarks.revoked_before, EXCLUDED.revoked_before);
+
+-- name: GetAccessTokenWatermarks :many
+SELECT * FROM access_token_watermarks
+WHERE revoked_before > $1;
@@ -0,0 +1,52 @@
+-- name: UpsertPendingTOTPCredential :one
+INSERT INTO totp_credentials(user_id, created_at, updated_at, secret)
+VALUES (
+    $1,
+    NOW(),
+    NOW(),
+    $2
+)
+ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret,
+updated_at = NOW()
+WHERE totp_credentials.confirmed_at IS NULL
+RETURNING *;
+
+-- name: GetTOTPCredential :one
+SELECT * FROM totp_credentials
+WHERE user_id = $1;
+
+-- name: ConfirmTOTPCredential :one
+UPDATE totp_credentials SET confirmed_at = NOW(),
+updated_at = NOW(),
+last_used_step = sqlc.arg(step)::BIGINT
+WHERE user_id = sqlc.arg(user_id)
+AND confirmed_at IS NULL
+RETURNING *;
+
+-- name: UseTOTPStep :one
+UPDATE totp_credentials SET last_used_step = sqlc.arg(step)::BIGINT,
+updated_at = NOW()
+WHERE user_id = sqlc.arg(user_id)
+AND confirmed_at IS NOT NULL
+AND (last_used_step IS NULL OR last_used_step < sqlc.arg(step)::BIGINT)
+RETURNING *;
+
+-- name: CreateRecoveryCode :exec
+INSERT INTO recovery_codes(id, created_at, code_hash, user_id)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    $1,
+    $2
+);
+
+-- name: DeleteRecoveryCodes :exec
+DELETE FROM recovery_codes
+WHERE user_id = $1;
+
+-- name: UseRecoveryCode :one
+UPDATE recovery_codes SET used_at = NOW()
+WHERE user_id = $1
+AND code_hash = $2
+AND used_at IS NULL
+RETURNING *;
@@ -0,0 +1,40 @@
+-- name: CreateUserIdentity :one
+INSERT INTO user_identities(id, issuer, subject, email, created_at, last_login_at, user_id)
+VALUES (
+    gen_random_uuid(),
+    $1,
+    $2,
+    $3,
+    NOW(),
+    NOW(),
+    $4
+)
+RETURNING *;
+
+-- name: GetUserIdentity :one
+SELECT * FROM user_identities
+WHERE issuer = $1
+AND subject = $2;
+
+-- name: TouchUserIdentity :exec
+UPDATE user_identities
+SET
+    email = $2,
+    last_login_at = NOW()
+WHERE id = $1;
+
+-- name: CreateOIDCLoginState :exec
+INSERT INTO oidc_login_states(state_hash, nonce, code_verifier, created_at, expires_at)
+VALUES (
+    $1,
+    $2,
+    $3,
+    NOW(),
+    $4
+);
+
+-- name: ConsumeOIDCLoginState :one
+DELETE FROM oidc_login_states
+WHERE state_hash = $1
+AND expires_at > NOW()
+RETURNING *;
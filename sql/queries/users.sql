@@ -13,18 +13,92 @@ RETURNING *;
 SELECT * FROM users
 WHERE email = $1;
 
+-- name: GetUserByID :one
+SELECT * FROM users
+WHERE id = $1;
+
 -- name: UpdateUser :one
 UPDATE users
     set email = $2,
     hashed_password = $3,
+    email_verified_at = CASE WHEN email = $2 THEN email_verified_at ELSE NULL END,
+    updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: UpdateUserPassword :one
+UPDATE users
+SET
+    hashed_password = $2,
+    updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: RehashUserPassword :exec
+UPDATE users
+SET hashed_password = sqlc.arg(new_hash)
+WHERE id = sqlc.arg(id)
+AND hashed_password = sqlc.arg(old_hash);
+
+-- name: VerifyUserEmail :one
+UPDATE users
+SET
+    email_verified_at = COALESCE(email_verified_at, NOW()),
+    updated_at = NOW()
+WHERE id = $1
+AND email = $2
+RETURNING *;
+
+-- name: SetUserRole :one
+UPDATE users
+SET
+    role = $2,
     updated_at = NOW()
 WHERE id = $1
 RETURNING *;
 
+-- name: SetUserRoleByEmail :one
+UPDATE users
+SET
+    role = $2,
+    updated_at = NOW()
+WHERE email = $1
+RETURNING *;
+
 -- name: UpgradeUserToChirpyRed :one
 UPDATE users
 SET
     is_chirpy_red = true,
     updated_at = NOW()
 WHERE id = $1
-RETURNING *;
\ No newline at end of file
+RETURNING *;
+
+-- name: DeactivateUser :one
+UPDATE users
+    set deactivated_at = NOW(),
+    updated_at = NOW()
+WHERE id = $1
+AND deactivated_at IS NULL
+RETURNING *;
+
+-- name: ReactivateUser :exec
+UPDATE users
+    set deactivated_at = NULL,
+    updated_at = NOW()
+WHERE id = $1;
+
+-- name: PurgeDeactivatedUsers :many
+DELETE FROM users
+WHERE deactivated_at < sqlc.arg(deactivated_before)::timestamp
+RETURNING id;
+
+-- name: CreateDeletedAuthor :exec
+INSERT INTO users (id, created_at, updated_at, email, hashed_password)
+VALUES (
+    sqlc.arg(id)::uuid,
+    NOW(),
+    NOW(),
+    'deleted-user',
+    'unusable'
+)
+ON CONFLICT (id) DO NOTHING;
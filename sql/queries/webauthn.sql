@@ -0,0 +1,56 @@
+-- name: CreateWebAuthnCredential :one
+INSERT INTO webauthn_credentials(id, credential_id, public_key, attestation_type, transports, aaguid, sign_count, backup_eligible, backup_state, name, created_at, user_id)
+VALUES (
+    gen_random_uuid(),
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    $7,
+    $8,
+    $9,
+    NOW(),
+    $10
+)
+RETURNING *;
+
+-- name: GetUserWebAuthnCredentials :many
+SELECT * FROM webauthn_credentials
+WHERE user_id = $1
+ORDER BY created_at;
+
+-- name: UpdateWebAuthnCredentialUsage :exec
+UPDATE webauthn_credentials
+SET
+    sign_count = $2,
+    backup_state = $3,
+    last_used_at = NOW()
+WHERE credential_id = $1;
+
+-- name: DeleteWebAuthnCredential :execrows
+DELETE FROM webauthn_credentials
+WHERE id = $1
+AND user_id = $2;
+
+-- name: CreateWebAuthnSession :one
+INSERT INTO webauthn_sessions(id, data, created_at, expires_at, user_id)
+VALUES (
+    gen_random_uuid(),
+    $1,
+    NOW(),
+    $2,
+    $3
+)
+RETURNING *;
+
+-- name: ConsumeWebAuthnSession :one
+DELETE FROM webauthn_sessions
+WHERE id = $1
+AND expires_at > NOW()
+RETURNING *;
+
+-- name: DeleteExpiredWebAuthnSessions :execrows
+DELETE FROM webauthn_sessions
+WHERE expires_at < NOW();
@@ -0,0 +1,23 @@
+-- +goose Up
+CREATE TABLE totp_credentials(
+  user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+  created_at TIMESTAMP NOT NULL,
+  updated_at TIMESTAMP NOT NULL,
+  secret TEXT NOT NULL,
+  confirmed_at TIMESTAMP,
+  last_used_step BIGINT
+);
+
+CREATE TABLE recovery_codes(
+  id UUID PRIMARY KEY,
+  created_at TIMESTAMP NOT NULL,
+  code_hash TEXT NOT NULL,
+  used_at TIMESTAMP,
+  user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX recovery_codes_user_id_idx ON recovery_codes(user_id);
+
+-- +goose Down
+DROP TABLE recovery_codes;
+DROP TABLE totp_credentials;
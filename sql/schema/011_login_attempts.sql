@@ -0,0 +1,9 @@
+-- +goose Up
+CREATE TABLE login_attempts(
+  key TEXT PRIMARY KEY,
+  failures INTEGER NOT NULL,
+  last_failure_at TIMESTAMP NOT NULL
+);
+
+-- +goose Down
+DROP TABLE login_attempts;
@@ -0,0 +1,30 @@
+-- +goose Up
+CREATE TABLE webauthn_credentials(
+  id UUID PRIMARY KEY,
+  credential_id BYTEA NOT NULL UNIQUE,
+  public_key BYTEA NOT NULL,
+  attestation_type TEXT NOT NULL,
+  transports TEXT NOT NULL,
+  aaguid BYTEA NOT NULL,
+  sign_count BIGINT NOT NULL,
+  backup_eligible BOOLEAN NOT NULL,
+  backup_state BOOLEAN NOT NULL,
+  name TEXT NOT NULL,
+  created_at TIMESTAMP NOT NULL,
+  last_used_at TIMESTAMP,
+  user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX webauthn_credentials_user_id_idx ON webauthn_credentials(user_id);
+
+CREATE TABLE webauthn_sessions(
+  id UUID PRIMARY KEY,
+  data TEXT NOT NULL,
+  created_at TIMESTAMP NOT NULL,
+  expires_at TIMESTAMP NOT NULL,
+  user_id UUID REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- +goose Down
+DROP TABLE webauthn_sessions;
+DROP TABLE webauthn_credentials;
@@ -0,0 +1,16 @@
+-- +goose Up
+CREATE TABLE revoked_access_tokens(
+  jti TEXT PRIMARY KEY,
+  revoked_at TIMESTAMP NOT NULL,
+  expires_at TIMESTAMP NOT NULL
+);
+
+-- Access tokens of a user issued before revoked_before are no longer valid.
+CREATE TABLE access_token_watermarks(
+  user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+  revoked_before TIMESTAMP NOT NULL
+);
+
+-- +goose Down
+DROP TABLE access_token_watermarks;
+DROP TABLE revoked_access_tokens;
@@ -0,0 +1,15 @@
+-- +goose Up
+-- Entries keep the IDs of deleted users, so there are no foreign keys.
+CREATE TABLE audit_log(
+  id UUID PRIMARY KEY,
+  action TEXT NOT NULL,
+  actor_id UUID NOT NULL,
+  subject_id UUID NOT NULL,
+  details TEXT NOT NULL,
+  created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX audit_log_created_at_idx ON audit_log(created_at);
+
+-- +goose Down
+DROP TABLE audit_log;
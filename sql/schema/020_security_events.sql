@@ -0,0 +1,32 @@
+-- +goose Up
+-- Security events are append-only: a trigger refuses updates and deletes.
+-- Events keep the IDs of deleted users and of failed logins for unknown
+-- addresses, so user_id is nullable and has no foreign key.
+CREATE TABLE security_events(
+  id UUID PRIMARY KEY,
+  user_id UUID,
+  event_type TEXT NOT NULL,
+  ip_address TEXT NOT NULL,
+  user_agent TEXT NOT NULL,
+  details TEXT NOT NULL,
+  created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX security_events_user_id_idx ON security_events(user_id, created_at);
+CREATE INDEX security_events_created_at_idx ON security_events(created_at);
+
+-- +goose StatementBegin
+CREATE FUNCTION security_events_append_only() RETURNS trigger AS $$
+BEGIN
+  RAISE EXCEPTION 'security_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER security_events_append_only
+BEFORE UPDATE OR DELETE ON security_events
+FOR EACH ROW EXECUTE FUNCTION security_events_append_only();
+
+-- +goose Down
+DROP TABLE security_events;
+DROP FUNCTION security_events_append_only();
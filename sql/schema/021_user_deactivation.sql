@@ -0,0 +1,9 @@
+-- +goose Up
+-- Set when the user asks for their account to be deleted. The account is
+-- purged once the grace period has passed, unless the user logs back in.
+ALTER TABLE users ADD COLUMN deactivated_at TIMESTAMP;
+
+CREATE INDEX users_deactivated_at_idx ON users(deactivated_at) WHERE deactivated_at IS NOT NULL;
+
+-- +goose Down
+ALTER TABLE users DROP COLUMN deactivated_at;
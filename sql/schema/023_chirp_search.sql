@@ -0,0 +1,10 @@
+-- +goose Up
+-- Postgres keeps the generated column in step with the body, so chirps are
+-- searchable as soon as they are created or edited.
+ALTER TABLE chirps ADD COLUMN search_vector tsvector
+  GENERATED ALWAYS AS (to_tsvector('english', body)) STORED;
+
+CREATE INDEX chirps_search_vector_idx ON chirps USING GIN (search_vector);
+
+-- +goose Down
+ALTER TABLE chirps DROP COLUMN search_vector;
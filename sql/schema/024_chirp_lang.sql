@@ -0,0 +1,7 @@
+-- +goose Up
+-- The ISO 639-1 code of the language a chirp is written in, if the client
+-- said which.
+ALTER TABLE chirps ADD COLUMN lang TEXT;
+
+-- +goose Down
+ALTER TABLE chirps DROP COLUMN lang;
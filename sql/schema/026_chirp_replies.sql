@@ -0,0 +1,20 @@
+-- +goose Up
+-- in_reply_to is the chirp a reply answers and root_id the first chirp of
+-- its conversation; both are NULL for chirps that start one. Deleting a
+-- chirp that has replies only empties it and sets deleted_at, so the
+-- conversation keeps its shape. When an author's account is purged, their
+-- chirps with replies are handed to a placeholder account as tombstones
+-- first, so SET NULL only applies to chirps removed by hand.
+ALTER TABLE chirps
+    ADD COLUMN in_reply_to UUID REFERENCES chirps(id) ON DELETE SET NULL,
+    ADD COLUMN root_id UUID REFERENCES chirps(id) ON DELETE SET NULL,
+    ADD COLUMN deleted_at TIMESTAMP;
+
+CREATE INDEX chirps_in_reply_to_idx ON chirps(in_reply_to, created_at, id) WHERE in_reply_to IS NOT NULL;
+CREATE INDEX chirps_root_id_idx ON chirps(root_id) WHERE root_id IS NOT NULL;
+
+-- +goose Down
+ALTER TABLE chirps
+    DROP COLUMN deleted_at,
+    DROP COLUMN root_id,
+    DROP COLUMN in_reply_to;
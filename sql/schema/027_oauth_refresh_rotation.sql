@@ -0,0 +1,7 @@
+-- +goose Up
+-- Set when a refresh token was revoked because it was exchanged for a new
+-- one. Presenting such a token again means it was copied.
+ALTER TABLE oauth_refresh_tokens ADD COLUMN rotated_at TIMESTAMP;
+
+-- +goose Down
+ALTER TABLE oauth_refresh_tokens DROP COLUMN rotated_at;